@@ -0,0 +1,345 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// What happens to an album's images when the album is deleted, chosen with
+// ALBUM_DELETE_MODE.
+const (
+	albumDeleteOrphan  = "orphan"  // Images stay, with no album
+	albumDeleteCascade = "cascade" // Images are deleted with the album
+)
+
+const maxAlbumNameLength = 255 // Matches the albums.name column
+
+// Album is a named group of images. An image belongs to at most one album.
+type Album struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Owner      *string   `json:"owner"` // Principal that created it; null for anonymous requests
+	ImageCount int       `json:"image_count"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateAlbumRequest is the body of POST /api/albums.
+type CreateAlbumRequest struct {
+	Name string `json:"name"`
+}
+
+// AlbumImagesRequest is the body of POST and DELETE /api/albums/{id}/images.
+type AlbumImagesRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// AlbumImagesResponse reports how many images were added or removed.
+type AlbumImagesResponse struct {
+	Affected int `json:"affected"`
+}
+
+const albumColumns = `id, name, owner, created_at, (SELECT COUNT(*) FROM images WHERE album_id = albums.id)`
+
+func scanAlbum(row rowScanner) (Album, error) {
+	var a Album
+	err := row.Scan(&a.ID, &a.Name, &a.Owner, &a.CreatedAt, &a.ImageCount)
+	return a, err
+}
+
+// albumsHandler handles GET and POST /api/albums.
+func albumsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listAlbumsHandler(w, r)
+	case http.MethodPost:
+		createAlbumHandler(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Only GET and POST methods are allowed")
+	}
+}
+
+func listAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	rows, err := dbQuery(ctx, "SELECT "+albumColumns+" FROM albums ORDER BY name")
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	albums := []Album{}
+	for rows.Next() {
+		a, err := scanAlbum(rows)
+		if err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+			return
+		}
+		albums = append(albums, a)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(albums)
+}
+
+func createAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateAlbumRequest
+	if !decodeJSONBody(w, r, &req, "name") {
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len([]rune(name)) > maxAlbumNameLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("name is required and must be at most %d characters", maxAlbumNameLength))
+		return
+	}
+	var owner sql.NullString
+	if p := principalFromContext(r.Context()); p != nil {
+		owner = sql.NullString{String: p.ID, Valid: true}
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	album, err := scanAlbum(dbQueryRow(ctx, "INSERT INTO albums (name, owner) VALUES ($1, $2) RETURNING "+albumColumns, name, owner))
+	if err != nil {
+		if isUniqueViolation(err, "albums_name_key") {
+			writeError(w, http.StatusConflict, "An album with this name already exists")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error creating album: "+err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", externalURL(r, fmt.Sprintf("/api/albums/%d", album.ID)))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(album)
+}
+
+// albumResourceHandler routes requests under /api/albums/{id}.
+func albumResourceHandler(w http.ResponseWriter, r *http.Request) {
+	idStr, subPath, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/albums/"), "/")
+	albumID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid album ID format")
+		return
+	}
+
+	switch {
+	case subPath == "" && r.Method == http.MethodGet:
+		getAlbumHandler(w, r, albumID)
+	case subPath == "" && r.Method == http.MethodDelete:
+		deleteAlbumHandler(w, r, albumID)
+	case subPath == "":
+		writeError(w, http.StatusMethodNotAllowed, "Only GET and DELETE methods are allowed")
+	case subPath == "images":
+		albumImagesHandler(w, r, albumID)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func getAlbumHandler(w http.ResponseWriter, r *http.Request, albumID int) {
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	album, err := scanAlbum(dbQueryRow(ctx, "SELECT "+albumColumns+" FROM albums WHERE id = $1", albumID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Album not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying album from database: "+err.Error())
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(album)
+}
+
+// albumImagesHandler handles POST (add) and DELETE (remove) on
+// /api/albums/{id}/images. Adding an image moves it out of any other album;
+// removing only affects images currently in this album.
+func albumImagesHandler(w http.ResponseWriter, r *http.Request, albumID int) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST and DELETE methods are allowed")
+		return
+	}
+	var req AlbumImagesRequest
+	if !decodeJSONBody(w, r, &req, "ids") {
+		return
+	}
+	imageIDs := uniqueIDs(req.IDs)
+	if len(imageIDs) == 0 || len(imageIDs) > maxBulkTagImages {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("ids must contain between 1 and %d images", maxBulkTagImages))
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	var exists bool
+	if err := dbQueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM albums WHERE id = $1)", albumID).Scan(&exists); err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying album from database: "+err.Error())
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, "Album not found")
+		return
+	}
+	missing, err := missingImageIDs(ctx, imageIDs)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying images from database: "+err.Error())
+		return
+	}
+	if len(missing) > 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Images not found: %v", missing))
+		return
+	}
+
+	query := "UPDATE images SET album_id = $1 WHERE id = ANY($2) AND album_id IS DISTINCT FROM $1 RETURNING id"
+	if r.Method == http.MethodDelete {
+		query = "UPDATE images SET album_id = NULL WHERE id = ANY($2) AND album_id = $1 RETURNING id"
+	}
+	rows, err := dbQuery(ctx, query, albumID, pq.Array(imageIDs))
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error updating album images: "+err.Error())
+		return
+	}
+	defer rows.Close()
+	affected := 0
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+			return
+		}
+		imageCache.InvalidateID(id)
+		affected++
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AlbumImagesResponse{Affected: affected})
+}
+
+// deleteAlbumHandler handles DELETE /api/albums/{id}. Under the orphan mode
+// the album's images stay without an album; under cascade they are deleted
+// in the same transaction and audited, and their files removed afterwards.
+func deleteAlbumHandler(w http.ResponseWriter, r *http.Request, albumID int) {
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	tx, err := dbBegin(ctx)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error starting transaction: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	type deletedImage struct {
+		id        int
+		root      string
+		diskName  string
+		thumbName sql.NullString
+	}
+	var deleted []deletedImage
+	var orphaned []int
+	if cfg.AlbumDeleteMode == albumDeleteCascade {
+		rows, err := tx.QueryContext(ctx, "DELETE FROM images WHERE album_id = $1 RETURNING id, COALESCE(storage_root, ''), disk_filename, thumb_filename", albumID)
+		if err != nil {
+			writeError(w, dbErrorStatus(err), "Error deleting album images: "+err.Error())
+			return
+		}
+		for rows.Next() {
+			var d deletedImage
+			if err := rows.Scan(&d.id, &d.root, &d.diskName, &d.thumbName); err != nil {
+				rows.Close()
+				writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+				return
+			}
+			deleted = append(deleted, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			writeError(w, dbErrorStatus(err), "Error deleting album images: "+err.Error())
+			return
+		}
+	} else {
+		// The foreign key would orphan them too, but this way their IDs are
+		// known for cache invalidation.
+		rows, err := tx.QueryContext(ctx, "UPDATE images SET album_id = NULL WHERE album_id = $1 RETURNING id", albumID)
+		if err != nil {
+			writeError(w, dbErrorStatus(err), "Error updating album images: "+err.Error())
+			return
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+				return
+			}
+			orphaned = append(orphaned, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			writeError(w, dbErrorStatus(err), "Error updating album images: "+err.Error())
+			return
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM albums WHERE id = $1", albumID)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error deleting album: "+err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "Album not found")
+		return
+	}
+	if len(deleted) > 0 {
+		ids := make([]int, len(deleted))
+		for i, d := range deleted {
+			ids[i] = d.id
+		}
+		if err := recordAudit(ctx, tx, r, auditActionDelete, ids); err != nil {
+			writeError(w, dbErrorStatus(err), "Error recording audit entry: "+err.Error())
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error committing deletion: "+err.Error())
+		return
+	}
+
+	for _, d := range deleted {
+		imageCache.InvalidateID(d.id)
+		path := storagePath(d.root, d.diskName)
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Warning: failed to delete image file %s: %v", path, err)
+		}
+		removeThumbnail(d.thumbName)
+		removeDerivedImages(d.diskName)
+		publishImageEvent(eventImageDeleted, d.id, d.diskName)
+	}
+	for _, id := range orphaned {
+		imageCache.InvalidateID(id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SimpleResponse{Message: fmt.Sprintf("Album deleted (%d images deleted, %d kept without an album)", len(deleted), len(orphaned))})
+}
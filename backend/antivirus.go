@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// VirusScanner checks uploaded files for malware.
+type VirusScanner interface {
+	// Scan inspects the file at path and returns the name of the signature
+	// it matched, or "" if the file is clean.
+	Scan(ctx context.Context, path string) (signature string, err error)
+}
+
+var virusScanner VirusScanner = noopVirusScanner{} // Replaced in main when CLAMAV_ADDR is set
+
+// noopVirusScanner reports every file clean.
+type noopVirusScanner struct{}
+
+func (noopVirusScanner) Scan(context.Context, string) (string, error) { return "", nil }
+
+// clamdChunkSize is the largest chunk sent per INSTREAM length prefix.
+const clamdChunkSize = 64 << 10
+
+// clamdScanner streams files to a clamd daemon with its INSTREAM command.
+type clamdScanner struct {
+	addr    string        // host:port, or the path of a Unix socket
+	timeout time.Duration // Limit on each scan, connection included
+}
+
+func (s clamdScanner) Scan(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	network := "tcp"
+	if strings.HasPrefix(s.addr, "/") {
+		network = "unix"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, s.addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// Closing the connection unblocks any pending I/O when the request
+	// context ends before the deadline.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return "", s.ioError(ctx, err)
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := f.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return "", s.ioError(ctx, err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	// A zero-length chunk ends the stream.
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", s.ioError(ctx, err)
+	}
+
+	reply, err := io.ReadAll(io.LimitReader(conn, 4<<10))
+	if err != nil {
+		return "", s.ioError(ctx, err)
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// ioError prefers the context's error, which says why the connection was
+// cut, over the resulting network error.
+func (s clamdScanner) ioError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// parseClamdReply interprets a reply such as "stream: OK",
+// "stream: Eicar-Signature FOUND" or "INSTREAM size limit exceeded. ERROR".
+func parseClamdReply(reply string) (string, error) {
+	result := reply
+	if _, after, ok := strings.Cut(reply, ": "); ok {
+		result = after
+	}
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	case result == "":
+		return "", errors.New("clamd closed the connection without a reply")
+	default:
+		return "", fmt.Errorf("clamd: %s", result)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultModelPageSize = 50
+	maxModelPageSize     = 500
+)
+
+// ModelArtifact is a file produced by a training job, such as trained
+// weights, with the metrics reported for it.
+type ModelArtifact struct {
+	ID        int             `json:"id"`
+	JobID     int             `json:"job_id"`
+	ModelName string          `json:"model_name"` // From the job
+	Location  string          `json:"location"`   // Path or blob name of the artifact
+	Metrics   json.RawMessage `json:"metrics"`    // JSON object as recorded
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ModelPage is returned by GET /api/ml/models.
+type ModelPage struct {
+	Models []ModelArtifact `json:"models"`
+	Total  int             `json:"total"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+}
+
+// modelArtifactColumns is the column list scanned by scanModelArtifacts, for
+// model_artifacts a joined with training_jobs j.
+const modelArtifactColumns = "a.id, a.job_id, j.model_name, a.location, a.metrics, a.created_at"
+
+// jobArtifactsHandler serves GET /api/ml/jobs/{id}/artifacts, oldest first.
+func jobArtifactsHandler(w http.ResponseWriter, r *http.Request, jobID int) {
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	var exists bool
+	if err := dbQueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM training_jobs WHERE id = $1)", jobID).Scan(&exists); err != nil {
+		writeTrainingJobError(w, err)
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, "Training job not found")
+		return
+	}
+
+	artifacts, err := scanModelArtifacts(ctx, `
+		SELECT `+modelArtifactColumns+`
+		FROM model_artifacts a JOIN training_jobs j ON j.id = a.job_id
+		WHERE a.job_id = $1
+		ORDER BY a.id`, jobID)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying model artifacts: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(artifacts)
+}
+
+// modelsHandler serves GET /api/ml/models?limit=&offset=: the artifacts of
+// every training job, newest first.
+func modelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+	limit, offset, _, ok := parseLimitOffset(w, r, defaultModelPageSize, maxModelPageSize)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	page := ModelPage{Limit: limit, Offset: offset}
+	if err := dbQueryRow(ctx, "SELECT COUNT(*) FROM model_artifacts").Scan(&page.Total); err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+	var err error
+	page.Models, err = scanModelArtifacts(ctx, `
+		SELECT `+modelArtifactColumns+`
+		FROM model_artifacts a JOIN training_jobs j ON j.id = a.job_id
+		ORDER BY a.id DESC
+		LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying model artifacts: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// scanModelArtifacts runs a query selecting modelArtifactColumns. The result
+// is never nil, so it encodes as [] rather than null.
+func scanModelArtifacts(ctx context.Context, query string, args ...any) ([]ModelArtifact, error) {
+	rows, err := dbQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	artifacts := []ModelArtifact{}
+	for rows.Next() {
+		var a ModelArtifact
+		var metrics []byte
+		if err := rows.Scan(&a.ID, &a.JobID, &a.ModelName, &a.Location, &metrics, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.Metrics = metrics
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
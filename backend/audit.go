@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Audited actions.
+const (
+	auditActionDelete   = "delete"
+	auditActionReassign = "reassign" // Images moved to another owner
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// AuditEntry is one row of the audit trail of destructive operations.
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Action    string    `json:"action"`
+	Actor     *string   `json:"actor"` // Principal ID of the caller; null for anonymous requests
+	ImageIDs  []int64   `json:"image_ids"`
+	ClientIP  string    `json:"client_ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditPage is returned by GET /api/admin/audit.
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+}
+
+// recordAudit appends an audit entry inside tx, so it commits or rolls back
+// together with the operation it describes.
+func recordAudit(ctx context.Context, tx *sql.Tx, r *http.Request, action string, imageIDs []int) error {
+	// Anonymous requests leave actor NULL.
+	var actor sql.NullString
+	if p := principalFromContext(r.Context()); p != nil {
+		actor = sql.NullString{String: p.ID, Valid: true}
+	}
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO audit_log (action, actor, image_ids, client_ip) VALUES ($1, $2, $3, $4)",
+		action, actor, pq.Array(imageIDs), clientIP(r, cfg.TrustedProxies),
+	)
+	return err
+}
+
+// auditLogHandler handles GET /api/admin/audit?limit=&offset=, newest first.
+func auditLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	limit, offset, _, ok := parseLimitOffset(w, r, defaultAuditPageSize, maxAuditPageSize)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+
+	page := AuditPage{Entries: []AuditEntry{}, Limit: limit, Offset: offset}
+	if err := dbQueryRow(ctx, "SELECT COUNT(*) FROM audit_log").Scan(&page.Total); err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+
+	rows, err := dbQuery(ctx,
+		"SELECT id, action, actor, image_ids, client_ip, created_at FROM audit_log ORDER BY id DESC LIMIT $1 OFFSET $2",
+		limit, offset,
+	)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Action, &e.Actor, pq.Array(&e.ImageIDs), &e.ClientIP, &e.CreatedAt); err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+			return
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
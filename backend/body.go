@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// withBodyLimit caps the request body of next at limit bytes. Requests that
+// declare a larger Content-Length are refused before the handler runs;
+// chunked bodies are cut off by http.MaxBytesReader while being read.
+func withBodyLimit(limit int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeBodyTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next(w, r)
+	}
+}
+
+// decodeJSONBody strictly decodes the request body into v: the body must be
+// declared as application/json, hold exactly one JSON object, name no fields
+// v lacks, and give a non-null value for each of required. On failure it
+// writes 415 for another Content-Type, 413 for a body over the route's
+// limit, a 400 validation error naming the offending field otherwise, and
+// returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any, required ...string) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeBodyTooLarge(w, maxBytesErr.Limit)
+		} else {
+			writeError(w, http.StatusBadRequest, "Error reading request body: "+err.Error())
+		}
+		return false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeValidationError(w, http.StatusBadRequest, []FieldError{jsonFieldError(err)})
+		return false
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		writeValidationError(w, http.StatusBadRequest, []FieldError{{Field: "body", Reason: "must contain a single JSON object"}})
+		return false
+	}
+
+	// Decoding into v cannot tell a missing field from a zero value, so the
+	// required fields are looked up in the raw object.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeValidationError(w, http.StatusBadRequest, []FieldError{{Field: "body", Reason: "must be a JSON object"}})
+		return false
+	}
+	var missing []FieldError
+	for _, name := range required {
+		if raw, ok := fields[name]; !ok || string(raw) == "null" {
+			missing = append(missing, FieldError{Field: name, Reason: "is required"})
+		}
+	}
+	if len(missing) > 0 {
+		writeValidationError(w, http.StatusBadRequest, missing)
+		return false
+	}
+	return true
+}
+
+// jsonFieldError describes a decoding error from encoding/json, naming the
+// field at fault where the error identifies one and "body" otherwise.
+func jsonFieldError(err error) FieldError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return FieldError{Field: "body", Reason: "must not be empty"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return FieldError{Field: "body", Reason: "is truncated"}
+	case errors.As(err, &syntaxErr):
+		return FieldError{Field: "body", Reason: fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)}
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return FieldError{Field: "body", Reason: "must be a JSON object"}
+		}
+		return FieldError{Field: typeErr.Field, Reason: fmt.Sprintf("must be %s, not %s", jsonTypeName(typeErr.Type.String()), typeErr.Value)}
+	}
+	// DisallowUnknownFields reports `json: unknown field "name"` with no
+	// dedicated error type.
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return FieldError{Field: strings.Trim(name, `"`), Reason: "is not a known field"}
+	}
+	return FieldError{Field: "body", Reason: err.Error()}
+}
+
+// jsonTypeName names a Go destination type the way a JSON client thinks of it.
+func jsonTypeName(goType string) string {
+	switch {
+	case strings.HasPrefix(goType, "[]"):
+		return "an array"
+	case goType == "string":
+		return "a string"
+	case goType == "bool":
+		return "a boolean"
+	case strings.HasPrefix(goType, "int") || strings.HasPrefix(goType, "uint") || strings.HasPrefix(goType, "float"):
+		return "a number"
+	}
+	return "an object"
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the %d byte limit for this endpoint", limit))
+}
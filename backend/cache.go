@@ -0,0 +1,115 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// metadataCache is a size-bounded LRU of image metadata keyed by disk
+// filename, with entries expiring after a fixed TTL. It is safe for
+// concurrent use.
+type metadataCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List               // Front is most recently used
+	byName   map[string]*list.Element // disk_filename -> element holding *cacheEntry
+	byID     map[int]string           // image ID -> disk_filename, for invalidation by ID
+}
+
+type cacheEntry struct {
+	img     ImageMetadata
+	expires time.Time
+}
+
+var imageCache *metadataCache // Initialized in main; nil when caching is disabled
+
+func newMetadataCache(capacity int, ttl time.Duration) *metadataCache {
+	return &metadataCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		byName:   make(map[string]*list.Element),
+		byID:     make(map[int]string),
+	}
+}
+
+// Get returns the cached metadata for diskFilename if present and fresh.
+func (c *metadataCache) Get(diskFilename string) (ImageMetadata, bool) {
+	if c == nil {
+		return ImageMetadata{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.byName[diskFilename]
+	if !ok {
+		metadataCacheMisses.Add(1)
+		return ImageMetadata{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(elem)
+		metadataCacheMisses.Add(1)
+		return ImageMetadata{}, false
+	}
+	c.order.MoveToFront(elem)
+	metadataCacheHits.Add(1)
+	return entry.img, true
+}
+
+// Put stores img, evicting the least recently used entry when full.
+func (c *metadataCache) Put(img ImageMetadata) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.byName[img.DiskFilename]; ok {
+		c.removeElement(elem)
+	}
+	c.byName[img.DiskFilename] = c.order.PushFront(&cacheEntry{img: img, expires: time.Now().Add(c.ttl)})
+	c.byID[img.ID] = img.DiskFilename
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// InvalidateID drops the entry for the given image, if cached.
+func (c *metadataCache) InvalidateID(id int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if name, ok := c.byID[id]; ok {
+		if elem, ok := c.byName[name]; ok {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// removeElement unlinks elem from all indexes. The caller must hold c.mu.
+func (c *metadataCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cacheEntry)
+	delete(c.byName, entry.img.DiskFilename)
+	delete(c.byID, entry.img.ID)
+}
+
+// lookupImageByFilename returns the metadata for a disk filename, consulting
+// the cache before the database. It returns sql.ErrNoRows for unknown files.
+func lookupImageByFilename(ctx context.Context, diskFilename string) (ImageMetadata, error) {
+	if img, ok := imageCache.Get(diskFilename); ok {
+		return img, nil
+	}
+	img, err := scanImage(dbQueryRow(ctx, "SELECT "+imageColumns+" FROM images WHERE disk_filename = $1", diskFilename))
+	if err != nil {
+		return img, err
+	}
+	imageCache.Put(img)
+	return img, nil
+}
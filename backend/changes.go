@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const changesBatchSize = 500 // New images, and separately deletions, returned per call
+
+// ChangesResponse is returned by GET /api/images/changes. Clients pass MaxID
+// and MaxSeq back as since_id and since_seq, and call again at once while
+// HasMore is set.
+type ChangesResponse struct {
+	Images     []ImageMetadata `json:"images"`      // Created after since_id, in ID order
+	DeletedIDs []int           `json:"deleted_ids"` // Deleted after since_seq, in deletion order
+	MaxID      int             `json:"max_id"`
+	MaxSeq     int64           `json:"max_seq"`
+	HasMore    bool            `json:"has_more"`
+}
+
+// imageChangesHandler handles GET /api/images/changes?since_id=&since_seq=
+// for clients that cache the gallery and only fetch what changed. Images are
+// reported once, when created; later edits such as tag changes are not
+// tracked. Deletions come from the image_deletions tombstones, which a
+// trigger writes for every deleted row whatever the route.
+func imageChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+	sinceID, ok := nonNegativeParam(w, r, "since_id")
+	if !ok {
+		return
+	}
+	sinceSeq, ok := nonNegativeParam(w, r, "since_seq")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	resp := ChangesResponse{Images: []ImageMetadata{}, DeletedIDs: []int{}, MaxID: sinceID, MaxSeq: int64(sinceSeq)}
+
+	rows, err := dbQuery(ctx, "SELECT "+imageColumns+" FROM images WHERE id > $1 ORDER BY id LIMIT $2", sinceID, changesBatchSize+1)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+			return
+		}
+		if len(resp.Images) == changesBatchSize {
+			resp.HasMore = true
+			break
+		}
+		resp.Images = append(resp.Images, img)
+		resp.MaxID = img.ID
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+	rows.Close()
+
+	rows, err = dbQuery(ctx, "SELECT seq, image_id FROM image_deletions WHERE seq > $1 ORDER BY seq LIMIT $2", sinceSeq, changesBatchSize+1)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seq int64
+		var id int
+		if err := rows.Scan(&seq, &id); err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+			return
+		}
+		if len(resp.DeletedIDs) == changesBatchSize {
+			resp.HasMore = true
+			break
+		}
+		resp.DeletedIDs = append(resp.DeletedIDs, id)
+		resp.MaxSeq = seq
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+
+	if err := markFavorites(ctx, r, resp.Images); err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying favorites: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// nonNegativeParam parses an optional non-negative integer query parameter,
+// defaulting to 0. ok is false if a 400 response has been written.
+func nonNegativeParam(w http.ResponseWriter, r *http.Request, name string) (n int, ok bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		writeError(w, http.StatusBadRequest, name+" must be a non-negative integer")
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+)
+
+// Request headers in which a client may state the checksum of the uploaded
+// file.
+const (
+	headerContentMD5     = "Content-MD5"       // Base64 MD5 digest, as in RFC 1864
+	headerChecksumSHA256 = "X-Checksum-SHA256" // Hex or base64 SHA-256 digest
+)
+
+// claimedChecksum is a digest a client stated for its upload, checked
+// against the bytes actually received.
+type claimedChecksum struct {
+	header string
+	digest []byte
+	hash   hash.Hash // Fed while the upload streams to disk; nil for SHA-256, which is always computed
+}
+
+// parseClaimedChecksums reads the checksum headers of an upload request. It
+// returns a FieldError for each header that is present but malformed.
+func parseClaimedChecksums(r *http.Request) ([]claimedChecksum, []FieldError) {
+	var claims []claimedChecksum
+	var problems []FieldError
+	if v := r.Header.Get(headerContentMD5); v != "" {
+		digest, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(digest) != md5.Size {
+			problems = append(problems, FieldError{Field: headerContentMD5, Reason: "must be the base64-encoded MD5 digest of the file"})
+		} else {
+			claims = append(claims, claimedChecksum{header: headerContentMD5, digest: digest, hash: md5.New()})
+		}
+	}
+	if v := r.Header.Get(headerChecksumSHA256); v != "" {
+		digest, err := hex.DecodeString(v)
+		if err != nil {
+			digest, err = base64.StdEncoding.DecodeString(v)
+		}
+		if err != nil || len(digest) != sha256.Size {
+			problems = append(problems, FieldError{Field: headerChecksumSHA256, Reason: "must be the hex- or base64-encoded SHA-256 digest of the file"})
+		} else {
+			claims = append(claims, claimedChecksum{header: headerChecksumSHA256, digest: digest})
+		}
+	}
+	return claims, problems
+}
+
+// verifyChecksums compares each claim with the digest computed while the
+// upload was written, in constant time. sha256Sum is the upload's SHA-256.
+func verifyChecksums(claims []claimedChecksum, sha256Sum []byte) []FieldError {
+	var problems []FieldError
+	for _, c := range claims {
+		actual := sha256Sum
+		if c.hash != nil {
+			actual = c.hash.Sum(nil)
+		}
+		if subtle.ConstantTimeCompare(c.digest, actual) != 1 {
+			problems = append(problems, FieldError{Field: c.header, Reason: "does not match the received file"})
+		}
+	}
+	return problems
+}
+
+// fileSHA256 returns the hex SHA-256 digest of the file at path, as stored in
+// images.content_sha256.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
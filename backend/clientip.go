@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// clientIP returns the address of the client that sent r. X-Forwarded-For is
+// only consulted when the direct peer is a trusted proxy; it is then walked
+// right to left, skipping trusted hops, so entries a client prepends to spoof
+// its address are never reached.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !fromTrustedProxy(r, trustedProxies) {
+		return remote
+	}
+
+	candidate := remote
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			// A malformed entry cannot be trusted; stop at the last proxy we trust.
+			break
+		}
+		candidate = ip.String()
+		if !ipInNets(ip, trustedProxies) {
+			break
+		}
+	}
+	return candidate
+}
+
+// ipInNets reports whether ip falls within any of nets.
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs or bare IPs.
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: entry}
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			entry += "/" + strconv.Itoa(bits)
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
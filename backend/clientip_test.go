@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		trustProxies bool
+		want         string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, true, "203.0.113.7"},
+		{"untrusted peer ignores header", "203.0.113.7:5000", []string{"198.51.100.1"}, true, "203.0.113.7"},
+		{"no trusted proxies configured", "10.0.0.2:5000", []string{"198.51.100.1"}, false, "10.0.0.2"},
+		{"trusted proxy", "10.0.0.2:5000", []string{"198.51.100.1"}, true, "198.51.100.1"},
+		{"trusted proxy without header", "10.0.0.2:5000", nil, true, "10.0.0.2"},
+		{"chain of trusted proxies", "10.0.0.2:5000", []string{"198.51.100.1, 10.1.2.3, 192.168.1.1"}, true, "198.51.100.1"},
+		// A client-supplied entry left of the real address is never reached.
+		{"spoofed entry", "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.1"}, true, "198.51.100.1"},
+		{"split across headers", "10.0.0.2:5000", []string{"1.2.3.4", "198.51.100.1, 10.9.9.9"}, true, "198.51.100.1"},
+		{"malformed hop stops at the last trusted one", "10.0.0.2:5000", []string{"198.51.100.1, garbage, 10.1.1.1"}, true, "10.1.1.1"},
+		{"empty hops skipped", "10.0.0.2:5000", []string{"198.51.100.1, , "}, true, "198.51.100.1"},
+		{"IPv6 proxy", "[::1]:5000", []string{"2001:db8::1"}, true, "2001:db8::1"},
+		{"remote address without port", "203.0.113.7", nil, true, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			nets := trusted
+			if !tt.trustProxies {
+				nets = nil
+			}
+			if got := clientIP(r, nets); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"10.0.0.0/8", []string{"10.0.0.0/8"}, false},
+		{" 192.168.1.1 , ::1", []string{"192.168.1.1/32", "::1/128"}, false},
+		{"10.0.0.0/8,,", []string{"10.0.0.0/8"}, false},
+		{"not-an-ip", nil, true},
+		{"10.0.0.0/99", nil, true},
+	}
+	for _, tt := range tests {
+		nets, err := parseTrustedProxies(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTrustedProxies(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if len(nets) != len(tt.want) {
+			t.Errorf("parseTrustedProxies(%q) = %v, want %v", tt.value, nets, tt.want)
+			continue
+		}
+		for i, n := range nets {
+			if n.String() != tt.want[i] {
+				t.Errorf("parseTrustedProxies(%q)[%d] = %s, want %s", tt.value, i, n, tt.want[i])
+			}
+		}
+	}
+}
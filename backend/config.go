@@ -0,0 +1,458 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duplicate upload handling modes, selected via the DEDUP_MODE env var.
+const (
+	dedupModeReject = "reject" // Respond 409 with the existing image's ID
+	dedupModeReturn = "return" // Respond with the existing record, storing nothing new
+)
+
+// Config holds the settings read from the environment at startup.
+type Config struct {
+	DBHost     string
+	DBPort     int
+	DBUser     string
+	DBPassword string
+	DBName     string
+
+	UploadPaths         []string // Roots new uploads are spread across
+	UploadPathPolicy    string   // How a root is picked for each upload
+	UploadFieldName     string   // Multipart field expected to hold the upload
+	TempDir             string   // Where large multipart parts spill to disk during parsing
+	StrictDelete        bool     // Keep the row when its file cannot be removed
+	AlbumDeleteMode     string   // Whether deleting an album keeps or deletes its images
+	StripEXIF           bool     // Remove EXIF/GPS metadata from uploaded JPEGs
+	MaintenanceMode     bool     // Start read-only: mutating requests get 503
+	DedupMode           string
+	ConvertToWebP       bool
+	HEICConverter       string   // heif-convert compatible command used to decode HEIC/HEIF
+	HEICTranscode       bool     // Store HEIC/HEIF uploads as JPEG
+	ConvertQuality      int      // Encoder quality (1-100) of JPEG and WebP images the server encodes, except thumbnails; WEBP_QUALITY is its former name
+	ThumbQuality        int      // JPEG quality (1-100) of thumbnails
+	ServeWebP           bool     // Serve WebP variants of JPEG/PNG originals to clients that accept them
+	ServeWebPMinSize    int64    // Smaller originals are always served as stored
+	AllowedContentTypes []string // Media types accepted by the upload endpoint
+
+	MaxConcurrentUploads int           // Uploads processed at once
+	UploadLimitMode      string        // Whether uploads beyond the limit queue or are refused
+	UploadQueueTimeout   time.Duration // How long a queued upload waits for a slot
+	ProcessingWorkers    int           // Uploads decoded, converted and thumbnailed at once
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	UploadTimeout     time.Duration // Replaces the read/write deadlines for the upload route
+
+	SlowRequestThreshold time.Duration // Requests taking longer are logged as warnings
+	SlowUploadThreshold  time.Duration // Same, for the upload route
+	DebugLogging         bool          // Log routine events such as aborted uploads
+
+	DefaultPageSize int // Images per page of GET /api/images without ?limit=
+	MaxPageSize     int // Larger ?limit= values are lowered to this
+
+	DBRetryAttempts           int           // Attempts per query when it could not reach the database
+	DBRetryBaseDelay          time.Duration // Backoff before the first retry, doubled each attempt
+	DBConnectMaxRetries       int           // Startup connection attempts after the first
+	DBConnectRetryInterval    time.Duration // Wait before the first retry; doubled for each next one
+	DBConnectRetryMaxInterval time.Duration // Longest wait between retries
+	DBBreakerThreshold        int           // Consecutive transient failures that open the circuit breaker
+	DBBreakerCooldown         time.Duration // How long the breaker stays open before a trial request
+	DBQueryTimeout            time.Duration // Deadline for the database work of one request
+
+	TrustedProxies []*net.IPNet // Proxies whose X-Forwarded-For/-Proto/-Host headers are believed
+	PublicBaseURL  string       // External scheme, host and path prefix for generated URLs
+
+	CORSAllowedOrigins []string      // Browser origins allowed to call the API; "*" allows any, empty disables CORS
+	CORSExposedHeaders []string      // Response headers scripts on those origins may read
+	CORSMaxAge         time.Duration // How long browsers may cache a preflight response
+
+	ThumbnailSize      int  // Max width/height in pixels of generated thumbnails
+	ThumbnailsOnUpload bool // Generate thumbnails in the upload request; otherwise the prewarm worker does
+
+	ThumbnailPrewarmInterval time.Duration // How often missing thumbnails are looked for
+	ThumbnailPrewarmRate     int           // Max thumbnails generated per second in the background
+
+	MaxResizeDimension int // Largest ?w= or ?h= accepted when serving resized images
+	MaxMegapixels      int // Uploads with more pixels are refused before decoding; 0 disables the check
+	DataURIMaxBytes    int // Largest file the datauri endpoint will inline
+	MaxJSONBodyBytes   int // Largest request body accepted by JSON endpoints
+
+	MetadataCacheSize int           // Max cached image metadata entries; 0 disables the cache
+	MetadataCacheTTL  time.Duration // How long a cached entry stays valid
+
+	ModerationURL     string        // When set, uploads are POSTed here for approval
+	ModerationTimeout time.Duration // Limit on each call to the moderation service
+
+	ClamAVAddr    string        // clamd host:port or Unix socket path; uploads are scanned for malware when set
+	ClamAVTimeout time.Duration // Limit on each scan
+
+	TrainerURL            string        // When set, new training jobs are POSTed here
+	TrainerTimeout        time.Duration // Limit on each call to the trainer
+	TrainerTotalTimeout   time.Duration // Limit on all attempts to submit one job
+	TrainerRetryAttempts  int           // Calls made before giving up on a temporary failure
+	TrainerRetryBaseDelay time.Duration // Backoff before the first retry, doubled each attempt
+	TrainerRetryMaxDelay  time.Duration // Cap on the backoff between retries
+	TrainingWaitTimeout   time.Duration // Longest a /wait long-poll holds the request open
+
+	TrainingSimulationDuration time.Duration // How long a simulated job takes without TRAINER_URL
+
+	EventWebhookURL     string        // When set, image lifecycle events are POSTed here
+	EventWebhookTimeout time.Duration // Limit on each webhook delivery attempt
+
+	APIKeys []string // Keys accepted in X-API-Key, each an admin service principal; admin routes are closed without any
+
+	URLSigningKey string        // When set, serving files requires a signed URL
+	SignedURLTTL  time.Duration // Lifetime of URLs issued by the signed-url endpoint
+
+	PurgeInterval   time.Duration // How often the purge worker removes expired items
+	DerivedCacheTTL time.Duration // Age after which cached resized variants are deleted
+	JobRetention    time.Duration // How long finished background jobs stay queryable
+
+	DownloadFlushInterval time.Duration // How often in-memory download counts are written to the database
+
+	StaticDir string // Built SPA served at / with index.html as the fallback; unset serves a greeting
+
+	TLSCertFile string // PEM certificate; with TLSKeyFile, enables HTTPS
+	TLSKeyFile  string
+	TLSRedirect bool // Also listen on plain HTTP and redirect to HTTPS
+}
+
+// TLSEnabled reports whether the server should terminate TLS itself.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+var cfg *Config // Global configuration, loaded once in main
+
+// loadConfig reads and validates the environment. Every missing or invalid
+// variable is reported in the returned error, not just the first one.
+func loadConfig() (*Config, error) {
+	env := &envReader{}
+	c := &Config{
+		DBHost:     env.required("DB_HOST"),
+		DBPort:     env.port("DB_PORT"),
+		DBUser:     env.required("DB_USER"),
+		DBPassword: os.Getenv("DB_PASSWORD"),
+		DBName:     env.required("DB_NAME"),
+
+		UploadPaths:      env.list("UPLOAD_PATHS"),
+		UploadPathPolicy: env.oneOf("UPLOAD_PATH_POLICY", uploadPolicyMostFree, uploadPolicyMostFree, uploadPolicyRoundRobin),
+		UploadFieldName:  env.optional("UPLOAD_FIELD_NAME", "imageFile"),
+		TempDir:          env.optional("TEMP_DIR", defaultTempDir),
+
+		StrictDelete:    env.boolean("STRICT_DELETE", false),
+		StripEXIF:       env.boolean("STRIP_EXIF", false),
+		AlbumDeleteMode: env.oneOf("ALBUM_DELETE_MODE", albumDeleteOrphan, albumDeleteOrphan, albumDeleteCascade),
+
+		MaintenanceMode: env.boolean("MAINTENANCE_MODE", false),
+
+		DedupMode:        env.oneOf("DEDUP_MODE", dedupModeReject, dedupModeReject, dedupModeReturn),
+		ConvertToWebP:    env.boolean("CONVERT_TO_WEBP", false),
+		HEICConverter:    env.optional("HEIC_CONVERTER", ""),
+		HEICTranscode:    env.boolean("HEIC_TRANSCODE", true),
+		ConvertQuality:   env.intRange("CONVERT_QUALITY", env.intRange("WEBP_QUALITY", 80, 1, 100), 1, 100),
+		ThumbQuality:     env.intRange("THUMB_QUALITY", 85, 1, 100),
+		ServeWebP:        env.boolean("SERVE_WEBP", false),
+		ServeWebPMinSize: int64(env.intRange("SERVE_WEBP_MIN_SIZE", 64<<10, 0, maxUploadSize)),
+
+		AllowedContentTypes: env.mediaTypes("ALLOWED_CONTENT_TYPES", "image/jpeg", "image/png", "image/gif", "image/webp"),
+
+		MaxConcurrentUploads: env.intRange("MAX_CONCURRENT_UPLOADS", 8, 1, 1000),
+		UploadLimitMode:      env.oneOf("UPLOAD_LIMIT_MODE", uploadLimitWait, uploadLimitWait, uploadLimitReject),
+		UploadQueueTimeout:   env.duration("UPLOAD_QUEUE_TIMEOUT", 10*time.Second),
+		ProcessingWorkers:    env.intRange("IMAGE_PROCESSING_WORKERS", runtime.GOMAXPROCS(0), 1, 1000),
+
+		ReadHeaderTimeout: env.duration("READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       env.duration("READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      env.duration("WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       env.duration("IDLE_TIMEOUT", 120*time.Second),
+		UploadTimeout:     env.duration("UPLOAD_TIMEOUT", 5*time.Minute),
+
+		SlowRequestThreshold: env.duration("SLOW_REQUEST_THRESHOLD", time.Second),
+		SlowUploadThreshold:  env.duration("SLOW_UPLOAD_THRESHOLD", 30*time.Second),
+		DebugLogging:         env.boolean("DEBUG_LOGGING", false),
+
+		DefaultPageSize: env.intRange("DEFAULT_PAGE_SIZE", 50, 1, 10000),
+		MaxPageSize:     env.intRange("MAX_PAGE_SIZE", 500, 1, 10000),
+
+		DBRetryAttempts:           env.intRange("DB_RETRY_ATTEMPTS", 3, 1, 10),
+		DBRetryBaseDelay:          env.duration("DB_RETRY_BASE_DELAY", 100*time.Millisecond),
+		DBConnectMaxRetries:       env.intRange("DB_CONNECT_MAX_RETRIES", 10, 0, 10000),
+		DBConnectRetryInterval:    env.duration("DB_CONNECT_RETRY_INTERVAL", time.Second),
+		DBConnectRetryMaxInterval: env.duration("DB_CONNECT_RETRY_MAX_INTERVAL", 30*time.Second),
+		DBBreakerThreshold:        env.intRange("DB_BREAKER_THRESHOLD", 5, 1, 1000),
+		DBBreakerCooldown:         env.duration("DB_BREAKER_COOLDOWN", 30*time.Second),
+		DBQueryTimeout:            env.duration("DB_QUERY_TIMEOUT", 5*time.Second),
+
+		TrustedProxies: env.cidrs("TRUSTED_PROXIES"),
+		PublicBaseURL:  strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/"),
+
+		CORSAllowedOrigins: env.list("CORS_ALLOWED_ORIGINS"),
+		CORSExposedHeaders: env.list("CORS_EXPOSED_HEADERS"),
+		CORSMaxAge:         env.duration("CORS_MAX_AGE", 10*time.Minute),
+
+		ThumbnailSize:      env.intRange("THUMBNAIL_SIZE", 256, 16, 2048),
+		ThumbnailsOnUpload: env.boolean("THUMBNAILS_ON_UPLOAD", true),
+
+		ThumbnailPrewarmInterval: env.duration("THUMBNAIL_PREWARM_INTERVAL", 5*time.Minute),
+		ThumbnailPrewarmRate:     env.intRange("THUMBNAIL_PREWARM_RATE", 2, 1, 100),
+
+		MaxResizeDimension: env.intRange("MAX_RESIZE_DIMENSION", 2048, 16, 8192),
+		MaxMegapixels:      env.intRange("MAX_MEGAPIXELS", 50, 0, 1000),
+		DataURIMaxBytes:    env.intRange("DATAURI_MAX_BYTES", 64<<10, 1, 10<<20),
+		MaxJSONBodyBytes:   env.intRange("MAX_JSON_BODY_BYTES", 1<<20, 1<<10, maxUploadSize),
+
+		MetadataCacheSize: env.intRange("METADATA_CACHE_SIZE", 1000, 0, 1_000_000),
+		MetadataCacheTTL:  env.duration("METADATA_CACHE_TTL", 5*time.Minute),
+
+		ModerationURL:     os.Getenv("MODERATION_URL"),
+		ModerationTimeout: env.duration("MODERATION_TIMEOUT", 10*time.Second),
+
+		ClamAVAddr:    os.Getenv("CLAMAV_ADDR"),
+		ClamAVTimeout: env.duration("CLAMAV_TIMEOUT", 30*time.Second),
+
+		TrainerURL:            os.Getenv("TRAINER_URL"),
+		TrainerTimeout:        env.duration("TRAINER_TIMEOUT", 5*time.Second),
+		TrainerTotalTimeout:   env.duration("TRAINER_TOTAL_TIMEOUT", 20*time.Second),
+		TrainerRetryAttempts:  env.intRange("TRAINER_RETRY_ATTEMPTS", 4, 1, 20),
+		TrainerRetryBaseDelay: env.duration("TRAINER_RETRY_BASE_DELAY", 500*time.Millisecond),
+		TrainerRetryMaxDelay:  env.duration("TRAINER_RETRY_MAX_DELAY", 5*time.Second),
+		TrainingWaitTimeout:   env.duration("TRAINING_WAIT_TIMEOUT", 25*time.Second),
+
+		TrainingSimulationDuration: env.duration("TRAINING_SIMULATION_DURATION", 30*time.Second),
+
+		EventWebhookURL:     os.Getenv("EVENT_WEBHOOK_URL"),
+		EventWebhookTimeout: env.duration("EVENT_WEBHOOK_TIMEOUT", 5*time.Second),
+
+		APIKeys: env.list("API_KEYS"),
+
+		URLSigningKey: os.Getenv("URL_SIGNING_KEY"),
+		SignedURLTTL:  env.duration("SIGNED_URL_TTL", 15*time.Minute),
+
+		PurgeInterval:   env.duration("PURGE_INTERVAL", time.Hour),
+		DerivedCacheTTL: env.duration("DERIVED_CACHE_TTL", 30*24*time.Hour),
+		JobRetention:    env.duration("JOB_RETENTION", 24*time.Hour),
+
+		DownloadFlushInterval: env.duration("DOWNLOAD_FLUSH_INTERVAL", 30*time.Second),
+
+		StaticDir: os.Getenv("STATIC_DIR"),
+
+		TLSCertFile: os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:  os.Getenv("TLS_KEY_FILE"),
+		TLSRedirect: env.boolean("TLS_REDIRECT", false),
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		env.fail("TLS_CERT_FILE", "must be set together with TLS_KEY_FILE")
+	}
+	if c.TrainerURL != "" && c.TrainerTotalTimeout >= c.WriteTimeout {
+		env.fail("TRAINER_TOTAL_TIMEOUT", "must be shorter than WRITE_TIMEOUT (%v) so the response can still be written", c.WriteTimeout)
+	}
+	if c.TrainingWaitTimeout >= c.WriteTimeout {
+		env.fail("TRAINING_WAIT_TIMEOUT", "must be shorter than WRITE_TIMEOUT (%v) so the response can still be written", c.WriteTimeout)
+	}
+	if c.PublicBaseURL != "" {
+		u, err := url.Parse(c.PublicBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			env.fail("PUBLIC_BASE_URL", "%q is not an http or https URL without query or fragment", c.PublicBaseURL)
+		}
+	}
+	if c.DBConnectRetryMaxInterval < c.DBConnectRetryInterval {
+		env.fail("DB_CONNECT_RETRY_MAX_INTERVAL", "must not be shorter than DB_CONNECT_RETRY_INTERVAL")
+	}
+	if c.DefaultPageSize > c.MaxPageSize {
+		env.fail("DEFAULT_PAGE_SIZE", "must not exceed MAX_PAGE_SIZE (%d)", c.MaxPageSize)
+	}
+	if c.TrainerRetryMaxDelay < c.TrainerRetryBaseDelay {
+		env.fail("TRAINER_RETRY_MAX_DELAY", "must not be shorter than TRAINER_RETRY_BASE_DELAY")
+	}
+	// HEIC is accepted by default once there is a converter to decode it.
+	if c.HEICConverter != "" && os.Getenv("ALLOWED_CONTENT_TYPES") == "" {
+		c.AllowedContentTypes = append(c.AllowedContentTypes, heifContentTypes...)
+	}
+	for _, t := range heifContentTypes {
+		if c.HEICConverter == "" && slices.Contains(c.AllowedContentTypes, t) {
+			env.fail("ALLOWED_CONTENT_TYPES", "%s requires HEIC_CONVERTER", t)
+		}
+	}
+	if c.StaticDir != "" {
+		if info, err := os.Stat(filepath.Join(c.StaticDir, "index.html")); err != nil || !info.Mode().IsRegular() {
+			env.fail("STATIC_DIR", "%q has no index.html", c.StaticDir)
+		}
+	}
+	if !filepath.IsAbs(c.TempDir) {
+		env.fail("TEMP_DIR", "%q is not an absolute path", c.TempDir)
+	}
+	c.TempDir = filepath.Clean(c.TempDir)
+	if len(c.CORSExposedHeaders) == 0 {
+		c.CORSExposedHeaders = defaultCORSExposedHeaders
+	}
+	if len(c.UploadPaths) == 0 {
+		c.UploadPaths = []string{uploadPath}
+	}
+	for i, root := range c.UploadPaths {
+		if !filepath.IsAbs(root) {
+			env.fail("UPLOAD_PATHS", "%q is not an absolute path", root)
+		}
+		c.UploadPaths[i] = filepath.Clean(root)
+	}
+	if c.TLSRedirect && !c.TLSEnabled() {
+		env.fail("TLS_REDIRECT", "requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// envReader reads env vars, collecting a problem for each invalid one.
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) fail(key, format string, args ...any) {
+	e.errs = append(e.errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+}
+
+func (e *envReader) required(key string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		e.fail(key, "is required")
+	}
+	return value
+}
+
+func (e *envReader) optional(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// list reads a comma-separated list, dropping empty items.
+func (e *envReader) list(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (e *envReader) port(key string) int {
+	value := e.required(key)
+	if value == "" {
+		return 0
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		e.fail(key, "%q is not a valid port number", value)
+	}
+	return port
+}
+
+func (e *envReader) oneOf(key, def string, allowed ...string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	e.fail(key, "%q must be one of %q", value, allowed)
+	return def
+}
+
+func (e *envReader) boolean(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.fail(key, "%q must be true or false", value)
+		return def
+	}
+	return b
+}
+
+func (e *envReader) intRange(key string, def, min, max int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		e.fail(key, "%q must be an integer between %d and %d", value, min, max)
+		return def
+	}
+	return n
+}
+
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		e.fail(key, "%q must be a positive duration such as \"30s\"", value)
+		return def
+	}
+	return d
+}
+
+// mediaTypes reads a comma-separated list of MIME types, normalized to
+// lowercase without parameters.
+func (e *envReader) mediaTypes(key string, def ...string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	var types []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		mediaType, _, err := mime.ParseMediaType(part)
+		if err != nil || !strings.Contains(mediaType, "/") {
+			e.fail(key, "%q is not a valid MIME type", part)
+			continue
+		}
+		if !slices.Contains(types, mediaType) {
+			types = append(types, mediaType)
+		}
+	}
+	if len(types) == 0 {
+		e.fail(key, "must list at least one MIME type")
+		return def
+	}
+	return types
+}
+
+func (e *envReader) cidrs(key string) []*net.IPNet {
+	nets, err := parseTrustedProxies(os.Getenv(key))
+	if err != nil {
+		e.fail(key, "%v", err)
+		return nil
+	}
+	return nets
+}
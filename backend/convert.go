@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	_ "image/jpeg" // Register JPEG decoder
+	_ "image/png"  // Register PNG decoder
+	"os"
+
+	"github.com/gen2brain/webp"
+)
+
+// convertedImage describes a file written by convertFileToWebP.
+type convertedImage struct {
+	DiskFilename string
+	ContentType  string
+	Size         int64
+}
+
+// convertFileToWebP re-encodes the JPEG, PNG or HEIF image stored as
+// srcFilename under root into a new WebP file in today's partition of the
+// same root. The source file is left in place; callers remove whichever file
+// they do not keep.
+func convertFileToWebP(root, srcFilename string) (*convertedImage, error) {
+	img, err := decodeImageFile(storagePath(root, srcFilename))
+	if err != nil {
+		return nil, fmt.Errorf("decoding source image: %w", err)
+	}
+
+	diskFilename, err := newDiskFilename(root, ".webp")
+	if err != nil {
+		return nil, err
+	}
+	dstPath := storagePath(root, diskFilename)
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = webp.Encode(dst, img, webp.Options{Quality: cfg.ConvertQuality})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dstPath)
+		return nil, fmt.Errorf("encoding WebP: %w", err)
+	}
+
+	info, err := os.Stat(dstPath)
+	if err != nil {
+		os.Remove(dstPath)
+		return nil, err
+	}
+	return &convertedImage{DiskFilename: diskFilename, ContentType: "image/webp", Size: info.Size()}, nil
+}
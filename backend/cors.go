@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultCORSExposedHeaders are the response headers the SPA reads when
+// CORS_EXPOSED_HEADERS is not set.
+var defaultCORSExposedHeaders = []string{"X-Request-ID", "X-Total-Count", "Link", "Location", "ETag", "X-List-Version", "Retry-After"}
+
+// corsAllowedMethods and corsAllowedHeaders answer every preflight; they
+// cover what the API's routes accept.
+const (
+	corsAllowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+	corsAllowedHeaders = "Content-Type, X-API-Key, X-Request-ID, If-None-Match, If-Modified-Since, X-List-Version, Content-MD5, X-Checksum-SHA256"
+)
+
+// corsMiddleware lets browsers on CORS_ALLOWED_ORIGINS call the API. It
+// answers preflights itself, before authentication and maintenance mode can
+// refuse them, and lets browsers cache them for CORS_MAX_AGE. With no
+// allowed origins it is a no-op and the API is same-origin only.
+func corsMiddleware(next http.Handler) http.Handler {
+	if len(cfg.CORSAllowedOrigins) == 0 {
+		return next
+	}
+	allowAny := slices.Contains(cfg.CORSAllowedOrigins, "*")
+	exposed := strings.Join(cfg.CORSExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.CORSMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(allowAny || slices.Contains(cfg.CORSAllowedOrigins, origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if exposed != "" {
+			h.Set("Access-Control-Expose-Headers", exposed)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// CountResponse is returned by GET /api/images/count.
+type CountResponse struct {
+	Count int `json:"count"`
+}
+
+// countImagesHandler handles GET /api/images/count, counting the images that
+// GET /api/images would list for the same filter parameters, so the SPA can
+// show how many match before an export or bulk operation.
+func countImagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	conditions, args, ok := imageListFilters(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+
+	query := "SELECT COUNT(*) FROM images"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	var resp CountResponse
+	if err := dbQueryRow(ctx, query, args...).Scan(&resp.Count); err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// DataURIResponse is returned by GET /api/images/{id}/datauri.
+type DataURIResponse struct {
+	DataURI string `json:"data_uri"`
+}
+
+// dataURIHandler returns a small preview of an image inline as a data URI.
+// The thumbnail is used when one exists, otherwise the original; either is
+// refused when larger than cfg.DataURIMaxBytes.
+func dataURIHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	var root, diskFilename, contentType string
+	var thumbFilename sql.NullString
+	err := dbQueryRow(ctx, "SELECT COALESCE(storage_root, ''), disk_filename, content_type, thumb_filename FROM images WHERE id = $1", imageID).
+		Scan(&root, &diskFilename, &contentType, &thumbFilename)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	if !mayReadImageFile(w, r, diskFilename) {
+		return
+	}
+
+	path := storagePath(root, diskFilename)
+	if thumbFilename.Valid {
+		path, contentType = thumbnailPath(thumbFilename.String), "image/jpeg"
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Image file not found")
+		return
+	}
+	if info.Size() > int64(cfg.DataURIMaxBytes) {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Image is %d bytes, larger than the %d byte data URI limit", info.Size(), cfg.DataURIMaxBytes))
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error reading image file: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DataURIResponse{DataURI: "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)})
+}
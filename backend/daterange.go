@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// uploadDateFilters builds WHERE conditions for the ?from= and ?to= list
+// parameters, RFC 3339 timestamps bounding uploaded_at inclusively.
+// Placeholders are numbered after the argCount arguments already in use.
+func uploadDateFilters(q url.Values, argCount int) ([]string, []any, error) {
+	var conditions []string
+	var args []any
+	var bounds [2]time.Time
+	for i, b := range []struct{ param, cond string }{
+		{"from", "uploaded_at >= $%d::timestamp"},
+		{"to", "uploaded_at <= $%d::timestamp"},
+	} {
+		v := q.Get(b.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s must be an RFC 3339 timestamp such as 2024-05-01T00:00:00Z", b.param)
+		}
+		// uploaded_at holds UTC without a zone, like the timestamps in cursors.
+		bounds[i] = t
+		args = append(args, t.UTC().Format(cursorTimeLayout))
+		conditions = append(conditions, fmt.Sprintf(b.cond, argCount+len(args)))
+	}
+	if !bounds[0].IsZero() && !bounds[1].IsZero() && bounds[0].After(bounds[1]) {
+		return nil, nil, fmt.Errorf("from must not be after to")
+	}
+	return conditions, args, nil
+}
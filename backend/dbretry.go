@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// connectDB opens and pings the database, retrying up to
+// DB_CONNECT_MAX_RETRIES times. The wait starts at DB_CONNECT_RETRY_INTERVAL
+// and doubles up to DB_CONNECT_RETRY_MAX_INTERVAL, so a database that is
+// slow to start is waited for patiently while one that is down is given up
+// on in bounded time.
+func connectDB(connStr string) (*sql.DB, error) {
+	log.Printf("Connecting to the database: up to %d retries, waiting %v doubling to at most %v between them",
+		cfg.DBConnectMaxRetries, cfg.DBConnectRetryInterval, cfg.DBConnectRetryMaxInterval)
+	wait := cfg.DBConnectRetryInterval
+	for retry := 0; ; retry++ {
+		conn, err := sql.Open("postgres", connStr)
+		if err == nil {
+			if err = conn.Ping(); err == nil {
+				return conn, nil
+			}
+			conn.Close() // Close this attempt before retrying
+		}
+		if retry >= cfg.DBConnectMaxRetries {
+			return nil, err
+		}
+		log.Printf("Database connection failed: %v. Retry %d/%d in %v...", err, retry+1, cfg.DBConnectMaxRetries, wait)
+		time.Sleep(wait)
+		wait = min(wait*2, cfg.DBConnectRetryMaxInterval)
+	}
+}
+
+// errCircuitOpen is returned without contacting the database while the
+// circuit breaker is open.
+var errCircuitOpen = errors.New("database unavailable: circuit breaker open")
+
+// circuitBreaker stops sending queries to a database that keeps failing with
+// transient errors, so requests fail fast instead of piling up behind it.
+// After the cooldown one trial request is let through; its outcome closes or
+// re-opens the circuit.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int           // Consecutive transient failures that open the circuit
+	cooldown  time.Duration // How long the circuit stays open
+	failures  int
+	openUntil time.Time
+	probing   bool // A trial request is in flight after the cooldown
+}
+
+var dbBreaker *circuitBreaker // Initialized in main from the config
+
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < cb.threshold {
+		return true
+	}
+	if time.Now().Before(cb.openUntil) || cb.probing {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+func (cb *circuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+	if errors.Is(err, context.Canceled) {
+		return // The caller gave up; says nothing about the database
+	}
+	if err == nil || !(isTransientDBError(err) || isDBTimeout(err)) {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		if cb.failures == cb.threshold {
+			log.Printf("Database circuit breaker opened after %d consecutive failures: %v", cb.failures, err)
+		}
+		cb.openUntil = time.Now().Add(cb.cooldown)
+	}
+}
+
+// isTransientDBError reports whether err is a connection-level failure that
+// may succeed on retry. Query errors such as constraint violations are not.
+func isTransientDBError(err error) bool {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	// Context errors satisfy net.Error, but retrying after the deadline or a
+	// client disconnect is pointless.
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is connection exceptions; 57P01-57P03 are server shutdown/startup.
+		return pqErr.Code.Class() == "08" || strings.HasPrefix(string(pqErr.Code), "57P0")
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isRetrySafeDBError reports whether err is a transient failure that left the
+// statement unsent: the connection could not be made, or a pooled one was
+// found broken before use. Only these are retried. After a reset or EOF
+// mid-statement the server may already have committed it, and replaying an
+// INSERT or UPDATE would apply it twice.
+func isRetrySafeDBError(err error) bool {
+	if !isTransientDBError(err) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Refused while connecting: 08001/08004 reject the connection and
+		// 57P03 means the server is still starting up.
+		switch pqErr.Code {
+		case "08001", "08004", "57P03":
+			return true
+		}
+	}
+	return false
+}
+
+// isDBTimeout reports whether err means a query outlived its context's
+// deadline, either noticed by database/sql or by Postgres cancelling it.
+func isDBTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014" // query_canceled
+}
+
+// dbContext bounds the database work of one request or job step by
+// DB_QUERY_TIMEOUT. Rows and transactions obtained with the returned context
+// must be finished before cancel is called.
+func dbContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, cfg.DBQueryTimeout)
+}
+
+// withDBRetry runs op, retrying failures that isRetrySafeDBError allows with
+// exponential backoff and consulting the circuit breaker before each attempt.
+// Retries stop once ctx is done.
+func withDBRetry(ctx context.Context, op func() error) error {
+	delay := cfg.DBRetryBaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		if !dbBreaker.allow() {
+			return errCircuitOpen
+		}
+		err = op()
+		dbBreaker.record(err)
+		if !isRetrySafeDBError(err) || attempt >= cfg.DBRetryAttempts {
+			return err
+		}
+		log.Printf("Transient database error (attempt %d/%d), retrying in %v: %v", attempt, cfg.DBRetryAttempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// dbQuery is db.QueryContext with retries for transient errors that left the
+// query unsent.
+func dbQuery(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := withDBRetry(ctx, func() (err error) {
+		rows, err = db.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// dbExec is db.ExecContext with retries for transient errors that left the
+// statement unsent, so a write is never applied twice.
+func dbExec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	var result sql.Result
+	err := withDBRetry(ctx, func() (err error) {
+		result, err = db.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// dbBegin is db.BeginTx with retries for transient errors. Statements inside
+// the transaction are not retried, and the transaction is rolled back if ctx
+// is done before it commits.
+func dbBegin(ctx context.Context) (*sql.Tx, error) {
+	var tx *sql.Tx
+	err := withDBRetry(ctx, func() (err error) {
+		tx, err = db.BeginTx(ctx, nil)
+		return err
+	})
+	return tx, err
+}
+
+// dbQueryRow is db.QueryRowContext with retries; the query runs when Scan
+// is called.
+func dbQueryRow(ctx context.Context, query string, args ...any) rowScanner {
+	return retryingRow{ctx: ctx, query: query, args: args}
+}
+
+type retryingRow struct {
+	ctx   context.Context
+	query string
+	args  []any
+}
+
+func (r retryingRow) Scan(dest ...any) error {
+	return withDBRetry(r.ctx, func() error {
+		return db.QueryRowContext(r.ctx, r.query, r.args...).Scan(dest...)
+	})
+}
+
+// dbErrorStatus maps a database error to a response status: 503 when the
+// database is unreachable, so clients know to retry, 504 when a query ran
+// past DB_QUERY_TIMEOUT, and 500 otherwise.
+func dbErrorStatus(err error) int {
+	if isDBTimeout(err) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, errCircuitOpen) || isTransientDBError(err) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestDBErrorClassification(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+		retrySafe bool
+	}{
+		{"nil", nil, false, false},
+		{"no rows", sql.ErrNoRows, false, false},
+		{"deadline", context.DeadlineExceeded, false, false},
+		{"canceled", context.Canceled, false, false},
+		{"unique violation", &pq.Error{Code: "23505"}, false, false},
+		{"bad conn", driver.ErrBadConn, true, true},
+		{"wrapped bad conn", fmt.Errorf("query: %w", driver.ErrBadConn), true, true},
+		{"connection refused", dialErr, true, true},
+		{"server starting up", &pq.Error{Code: "57P03"}, true, true},
+		{"connection rejected", &pq.Error{Code: "08004"}, true, true},
+		// The statement may have been applied before these.
+		{"unexpected EOF", io.ErrUnexpectedEOF, true, false},
+		{"EOF", io.EOF, true, false},
+		{"connection reset", readErr, true, false},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, true, false},
+		{"connection failure", &pq.Error{Code: "08006"}, true, false},
+		{"conn done", sql.ErrConnDone, true, false},
+		{"other", errors.New("syntax error"), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientDBError(tt.err); got != tt.transient {
+				t.Errorf("isTransientDBError(%v) = %v, want %v", tt.err, got, tt.transient)
+			}
+			if got := isRetrySafeDBError(tt.err); got != tt.retrySafe {
+				t.Errorf("isRetrySafeDBError(%v) = %v, want %v", tt.err, got, tt.retrySafe)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TableStats describes the size and bloat of the images table.
+type TableStats struct {
+	Table       string       `json:"table"`
+	Rows        int64        `json:"rows"`         // Exact count
+	TotalBytes  int64        `json:"total_bytes"`  // Heap, indexes and TOAST
+	TableBytes  int64        `json:"table_bytes"`  // Heap only
+	IndexBytes  int64        `json:"index_bytes"`  // All indexes together
+	LiveTuples  int64        `json:"live_tuples"`  // Statistics collector estimate
+	DeadTuples  int64        `json:"dead_tuples"`  // Estimate of rows awaiting vacuum
+	LastVacuum  *time.Time   `json:"last_vacuum"`  // Latest manual or auto vacuum
+	LastAnalyze *time.Time   `json:"last_analyze"` // Latest manual or auto analyze
+	Indexes     []IndexStats `json:"indexes"`
+}
+
+// IndexStats is the on-disk size of one index.
+type IndexStats struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// dbStatsHandler handles GET /api/admin/db-stats. It is wrapped in
+// requireAdmin.
+func dbStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	stats := TableStats{Table: "images", Indexes: []IndexStats{}}
+	var lastVacuum, lastAnalyze sql.NullTime
+	err := dbQueryRow(ctx, `
+		SELECT (SELECT COUNT(*) FROM images),
+			pg_total_relation_size(s.relid), pg_relation_size(s.relid), pg_indexes_size(s.relid),
+			s.n_live_tup, s.n_dead_tup,
+			GREATEST(s.last_vacuum, s.last_autovacuum), GREATEST(s.last_analyze, s.last_autoanalyze)
+		FROM pg_stat_user_tables s
+		WHERE s.relid = 'images'::regclass`,
+	).Scan(&stats.Rows, &stats.TotalBytes, &stats.TableBytes, &stats.IndexBytes,
+		&stats.LiveTuples, &stats.DeadTuples, &lastVacuum, &lastAnalyze)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying table statistics: "+err.Error())
+		return
+	}
+	if lastVacuum.Valid {
+		stats.LastVacuum = &lastVacuum.Time
+	}
+	if lastAnalyze.Valid {
+		stats.LastAnalyze = &lastAnalyze.Time
+	}
+
+	rows, err := dbQuery(ctx, `
+		SELECT indexrelname, pg_relation_size(indexrelid)
+		FROM pg_stat_user_indexes
+		WHERE relid = 'images'::regclass
+		ORDER BY indexrelname`)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying index sizes: "+err.Error())
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var idx IndexStats
+		if err := rows.Scan(&idx.Name, &idx.Bytes); err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+			return
+		}
+		stats.Indexes = append(stats.Indexes, idx)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// dbMaintenanceHandler handles POST /api/admin/db-maintenance by starting a
+// background job that runs VACUUM ANALYZE on the images table. It is wrapped
+// in requireAdmin.
+func dbMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+	job := backgroundJobs.Start("db-maintenance", vacuumImagesTable)
+	writeJobAccepted(w, r, job)
+}
+
+// vacuumImagesTable runs VACUUM ANALYZE, which cannot run inside a
+// transaction and can take far longer than DB_QUERY_TIMEOUT on a bloated
+// table, so it goes straight to the pool without either.
+func vacuumImagesTable(ctx context.Context, update func(func(*BackgroundJob))) error {
+	start := time.Now()
+	if _, err := db.ExecContext(ctx, "VACUUM ANALYZE images"); err != nil {
+		return err
+	}
+	log.Printf("VACUUM ANALYZE images finished in %v", time.Since(start))
+	update(func(j *BackgroundJob) { j.Processed = 1 })
+	return nil
+}
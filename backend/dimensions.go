@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// aspectTolerance is how far, relative to the requested ratio, an image's
+// aspect ratio may be from ?aspect= and still match.
+const aspectTolerance = 0.02
+
+// dimensionFilters builds WHERE conditions for the ?min_width=, ?max_width=,
+// ?min_height=, ?max_height= and ?aspect= list parameters. Placeholders are
+// numbered after the argCount arguments already in use. Images without known
+// dimensions never match a dimension filter.
+func dimensionFilters(q url.Values, argCount int) ([]string, []any, error) {
+	var conditions []string
+	var args []any
+	add := func(format string, values ...any) {
+		placeholders := make([]any, len(values))
+		for i := range values {
+			placeholders[i] = argCount + len(args) + i + 1
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+		args = append(args, values...)
+	}
+
+	bounds := []struct{ param, cond string }{
+		{"min_width", "width >= $%d"},
+		{"max_width", "width <= $%d"},
+		{"min_height", "height >= $%d"},
+		{"max_height", "height <= $%d"},
+	}
+	values := make(map[string]int)
+	for _, b := range bounds {
+		v := q.Get(b.param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, nil, fmt.Errorf("%s must be a positive integer", b.param)
+		}
+		values[b.param] = n
+		add(b.cond, n)
+	}
+	for _, dim := range []string{"width", "height"} {
+		lo, hasLo := values["min_"+dim]
+		hi, hasHi := values["max_"+dim]
+		if hasLo && hasHi && lo > hi {
+			return nil, nil, fmt.Errorf("min_%s must not exceed max_%s", dim, dim)
+		}
+	}
+
+	if v := q.Get("aspect"); v != "" {
+		ratio, err := parseAspect(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		add("height > 0 AND ABS(width::float8 / height - $%d) <= $%d", ratio, ratio*aspectTolerance)
+	}
+	return conditions, args, nil
+}
+
+// parseAspect parses an aspect ratio written as "W:H", e.g. "16:9" or "1.85:1".
+func parseAspect(s string) (float64, error) {
+	invalid := fmt.Errorf("aspect must be written as W:H, e.g. 16:9")
+	ws, hs, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, invalid
+	}
+	w, err1 := strconv.ParseFloat(ws, 64)
+	h, err2 := strconv.ParseFloat(hs, 64)
+	if err1 != nil || err2 != nil || !(w > 0) || !(h > 0) {
+		return 0, invalid
+	}
+	ratio := w / h
+	if math.IsInf(ratio, 0) || ratio == 0 {
+		return 0, invalid
+	}
+	return ratio, nil
+}
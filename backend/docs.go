@@ -0,0 +1,48 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is the handwritten OpenAPI 3 description of this API. Update it
+// alongside any change to routes, parameters or response shapes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// swaggerUIPage renders openAPISpec with Swagger UI loaded from a CDN.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Medical Image Backend API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/api/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+func openAPISpecHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
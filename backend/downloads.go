@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const mostDownloadedLimit = 10 // Images listed under most_downloaded in /api/stats
+
+// downloadCounter accumulates image downloads in memory between flushes, so
+// serving a file costs a map increment rather than a database write. It is
+// safe for concurrent use.
+type downloadCounter struct {
+	mu      sync.Mutex
+	pending map[int]int64 // image ID -> downloads not yet flushed
+}
+
+var downloads = &downloadCounter{pending: make(map[int]int64)}
+
+// Record counts one download of the image.
+func (c *downloadCounter) Record(imageID int) {
+	c.mu.Lock()
+	c.pending[imageID]++
+	c.mu.Unlock()
+}
+
+// take empties the counter and returns what it held.
+func (c *downloadCounter) take() map[int]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	taken := c.pending
+	c.pending = make(map[int]int64)
+	return taken
+}
+
+// restore adds counts back after a failed flush, so they go out with the next.
+func (c *downloadCounter) restore(counts map[int]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, n := range counts {
+		c.pending[id] += n
+	}
+}
+
+// Flush adds the pending counts to image_downloads in one statement. Counts
+// for images deleted in the meantime are dropped. On failure the counts are
+// kept for the next flush.
+func (c *downloadCounter) Flush(parent context.Context) error {
+	counts := c.take()
+	if len(counts) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(counts))
+	ns := make([]int64, 0, len(counts))
+	for id, n := range counts {
+		ids = append(ids, id)
+		ns = append(ns, n)
+	}
+
+	ctx, cancel := dbContext(parent)
+	defer cancel()
+	_, err := dbExec(ctx, `
+		INSERT INTO image_downloads (image_id, count, last_downloaded_at)
+		SELECT d.id, d.n, now() FROM unnest($1::int[], $2::bigint[]) AS d(id, n)
+		JOIN images ON images.id = d.id
+		ON CONFLICT (image_id) DO UPDATE
+			SET count = image_downloads.count + EXCLUDED.count, last_downloaded_at = EXCLUDED.last_downloaded_at`,
+		pq.Array(ids), pq.Array(ns),
+	)
+	if err != nil {
+		c.restore(counts)
+		downloadFlushFailures.Add(1)
+		return err
+	}
+	return nil
+}
+
+// runDownloadFlusher flushes the download counter every
+// DOWNLOAD_FLUSH_INTERVAL until ctx is done. The final flush is left to main,
+// after in-flight requests have finished. The returned channel is closed
+// once the worker has stopped.
+func runDownloadFlusher(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(cfg.DownloadFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := downloads.Flush(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Download count flush failed, retrying next interval: %v", err)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// recordDownload counts a GET that serves an image's file. HEAD requests
+// transfer nothing and are not counted.
+func recordDownload(r *http.Request, imageID int) {
+	if r.Method == http.MethodGet {
+		downloads.Record(imageID)
+	}
+}
+
+// ImageDownloads is one entry of the most-downloaded list in /api/stats.
+type ImageDownloads struct {
+	ID               int       `json:"id"`
+	OriginalFilename string    `json:"original_filename"`
+	Downloads        int64     `json:"downloads"`
+	LastDownloadedAt time.Time `json:"last_downloaded_at"`
+}
+
+// mostDownloadedImages returns the images with the highest flushed download
+// counts, most downloaded first.
+func mostDownloadedImages(ctx context.Context, limit int) ([]ImageDownloads, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT i.id, i.original_filename, d.count, d.last_downloaded_at
+		FROM image_downloads d JOIN images i ON i.id = d.image_id
+		ORDER BY d.count DESC, i.id
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	top := []ImageDownloads{}
+	for rows.Next() {
+		var d ImageDownloads
+		if err := rows.Scan(&d.ID, &d.OriginalFilename, &d.Downloads, &d.LastDownloadedAt); err != nil {
+			return nil, err
+		}
+		top = append(top, d)
+	}
+	return top, rows.Err()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// writeError responds with a JSON SimpleResponse carrying msg and a code
+// derived from status, so clients never have to parse plain-text errors.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	retryable := retryHint(w, status)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SimpleResponse{Error: msg, Code: errorCode(status), Retryable: &retryable})
+}
+
+// defaultRetryAfter is the Retry-After hint, in seconds, for retryable
+// errors whose handler did not set a more specific one.
+const defaultRetryAfter = "5"
+
+// retryHint reports whether a request that failed with status may succeed
+// when repeated unchanged, such as after a rate limit or while the database
+// is unavailable, and then makes sure the response carries a Retry-After
+// header. Client errors and server errors that are likely to recur, such as
+// a full disk, are not retryable. It must be called before WriteHeader.
+func retryHint(w http.ResponseWriter, status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if w.Header().Get("Retry-After") == "" {
+			w.Header().Set("Retry-After", defaultRetryAfter)
+		}
+		return true
+	}
+	return false
+}
+
+// codeValidationFailed is the Code of responses listing FieldErrors.
+const codeValidationFailed = "validation_failed"
+
+// FieldError describes one problem with one field of a request, so clients
+// can show it next to the right input.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// writeValidationError responds with every problem found in a request at
+// once, under the validation_failed code.
+func writeValidationError(w http.ResponseWriter, status int, details []FieldError) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	retryable := retryHint(w, status)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SimpleResponse{Error: "Request validation failed", Code: codeValidationFailed, Details: details, Retryable: &retryable})
+}
+
+// errorCode turns an HTTP status into a snake_case code such as "not_found".
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Image lifecycle event types.
+const (
+	eventImageUploaded = "image.uploaded"
+	eventImageDeleted  = "image.deleted"
+	eventImageReplaced = "image.replaced" // New file for an existing ID; see PUT /api/images/{id}/file
+)
+
+const (
+	eventQueueSize     = 1000 // Events buffered before new ones are dropped
+	eventMaxAttempts   = 3
+	eventRetryBaseWait = time.Second
+)
+
+// ImageEvent is the JSON body delivered for each lifecycle event.
+type ImageEvent struct {
+	Type         string    `json:"type"`
+	ID           int       `json:"id"`
+	DiskFilename string    `json:"disk_filename"`
+	Time         time.Time `json:"time"`
+}
+
+// EventSink receives image lifecycle events. Publish must not block the
+// calling request.
+type EventSink interface {
+	Publish(ImageEvent)
+}
+
+var events EventSink = noopEventSink{} // Replaced in main when EVENT_WEBHOOK_URL is set
+
+type noopEventSink struct{}
+
+func (noopEventSink) Publish(ImageEvent) {}
+
+// publishImageEvent stamps and publishes an event of the given type.
+func publishImageEvent(eventType string, id int, diskFilename string) {
+	events.Publish(ImageEvent{Type: eventType, ID: id, DiskFilename: diskFilename, Time: time.Now().UTC()})
+}
+
+// webhookSink POSTs events to a URL from a single background goroutine.
+// Events that arrive while the queue is full are dropped.
+type webhookSink struct {
+	url    string
+	client *http.Client
+	queue  chan ImageEvent
+}
+
+func newWebhookSink(url string, timeout time.Duration) *webhookSink {
+	s := &webhookSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan ImageEvent, eventQueueSize),
+	}
+	go s.run()
+	return s
+}
+
+func (s *webhookSink) Publish(e ImageEvent) {
+	select {
+	case s.queue <- e:
+	default:
+		eventsDropped.Add(1)
+		log.Printf("Warning: event queue full, dropping %s event for image %d", e.Type, e.ID)
+	}
+}
+
+func (s *webhookSink) run() {
+	for e := range s.queue {
+		wait := eventRetryBaseWait
+		for attempt := 1; ; attempt++ {
+			err := s.deliver(e)
+			if err == nil {
+				eventsDelivered.Add(1)
+				break
+			}
+			if attempt >= eventMaxAttempts {
+				eventsFailed.Add(1)
+				log.Printf("Warning: giving up on %s event for image %d after %d attempts: %v", e.Type, e.ID, attempt, err)
+				break
+			}
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+}
+
+func (s *webhookSink) deliver(e ImageEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// JPEG markers handled when rewriting metadata.
+const (
+	jpegSOI   = 0xD8
+	jpegEOI   = 0xD9
+	jpegSOS   = 0xDA
+	jpegAPP1  = 0xE1 // EXIF (including GPS) and XMP
+	jpegAPP13 = 0xED // IPTC / Photoshop
+)
+
+const exifOrientationTag = 0x0112
+
+var errNotJPEG = errors.New("not a JPEG file")
+
+// stripJPEGMetadata removes EXIF, GPS, XMP and IPTC metadata from the JPEG at
+// path, replacing the file. When the EXIF orientation is not the default the
+// pixels are rotated to match and re-encoded, since the tag that told viewers
+// to rotate them is going away; otherwise the metadata segments are dropped
+// without touching the image data. It reports whether anything was removed.
+func stripJPEGMetadata(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	segments, err := splitJPEGSegments(data)
+	if err != nil {
+		return false, err
+	}
+
+	orientation := 1
+	var kept [][]byte
+	for _, seg := range segments {
+		if len(seg) >= 2 && (seg[1] == jpegAPP1 || seg[1] == jpegAPP13) {
+			if o := exifOrientation(seg); o != 0 {
+				orientation = o
+			}
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	if len(kept) == len(segments) {
+		return false, nil
+	}
+
+	if orientation != 1 {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return false, err
+		}
+		err = replaceFile(path, func(tmp string) error {
+			_, err := writeImageFile(tmp, orientImage(img, orientation), imageEncoders["image/jpeg"])
+			return err
+		})
+		return err == nil, err
+	}
+	err = replaceFile(path, func(tmp string) error {
+		return os.WriteFile(tmp, bytes.Join(kept, nil), 0o666)
+	})
+	return err == nil, err
+}
+
+// replaceFile has write create a temporary file next to path and renames it
+// over path, so path never holds a partial file.
+func replaceFile(path string, write func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+uuid.New().String()+".tmp")
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// splitJPEGSegments splits a JPEG into its marker segments. The scan data
+// from SOS to the end of the file is returned as the last segment.
+func splitJPEGSegments(data []byte) ([][]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegSOI {
+		return nil, errNotJPEG
+	}
+	segments := [][]byte{data[:2]}
+	for i := 2; i < len(data); {
+		if data[i] != 0xFF || i+1 >= len(data) {
+			return nil, errNotJPEG
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: // Fill byte
+			i++
+			continue
+		case marker == jpegSOS || marker == jpegEOI:
+			return append(segments, data[i:]), nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // No length
+			segments = append(segments, data[i:i+2])
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, errNotJPEG
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			return nil, errNotJPEG
+		}
+		segments = append(segments, data[i:end])
+		i = end
+	}
+	return segments, nil
+}
+
+// maxJPEGHeaderRead bounds how much of a file jpegFileOrientation reads. An
+// APP1 segment is at most 64 KiB and EXIF comes first, so this is ample.
+const maxJPEGHeaderRead = 128 << 10
+
+// jpegFileOrientation returns the EXIF orientation (1-8) of the JPEG at
+// path, or 1 when it has none or is not a JPEG.
+func jpegFileOrientation(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	head := make([]byte, maxJPEGHeaderRead)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if n < 4 || head[0] != 0xFF || head[1] != jpegSOI {
+		return 1
+	}
+	// Walk the segments in the header read, stopping at the first one cut
+	// off by the read limit.
+	for i := 2; i+4 <= len(head) && head[i] == 0xFF; {
+		marker := head[i+1]
+		if marker == jpegSOS || marker == jpegEOI {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(head[i+2:]))
+		if end > len(head) {
+			break
+		}
+		if marker == jpegAPP1 {
+			if o := exifOrientation(head[i:end]); o != 0 {
+				return o
+			}
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation returns the orientation (1-8) recorded in an APP1 EXIF
+// segment, or 0 if the segment is not EXIF or has no valid orientation.
+func exifOrientation(seg []byte) int {
+	if len(seg) < 4+6+8 || string(seg[4:10]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := seg[10:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientImage applies an EXIF orientation (1-8) to img, returning it as it
+// should be displayed.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dst *image.NRGBA
+	if orientation >= 5 { // Transposed: width and height swap
+		dst = image.NewNRGBA(image.Rect(0, 0, h, w))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, w, h))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			switch orientation {
+			case 2:
+				dst.Set(w-1-x, y, c)
+			case 3:
+				dst.Set(w-1-x, h-1-y, c)
+			case 4:
+				dst.Set(x, h-1-y, c)
+			case 5:
+				dst.Set(y, x, c)
+			case 6:
+				dst.Set(h-1-y, x, c)
+			case 7:
+				dst.Set(h-1-y, w-1-x, c)
+			case 8:
+				dst.Set(y, w-1-x, c)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportImagesHandler streams the caller's images as a ZIP archive, naming
+// the entries after their original filenames. Admins export the whole
+// dataset; see ownedImagesCondition.
+func exportImagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+	if !mayReadImageFile(w, r, "") {
+		return
+	}
+
+	// The rows are read up front so the query is not held open, and subject to
+	// DB_QUERY_TIMEOUT, for as long as the archive takes to stream.
+	entries, err := loadExportEntries(r)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+
+	// The archive can take far longer to stream than the server-wide write timeout.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Warning: could not clear export write deadline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="images.zip"`)
+
+	// Once the first entry is written the status is committed, so failures
+	// past this point can only be logged and the archive left truncated.
+	zw := zip.NewWriter(w)
+	usedNames := make(map[string]bool)
+	for _, e := range entries {
+		name := exportEntryName(e.originalFilename, e.id, usedNames)
+		err := writeExportEntry(zw, name, storagePath(e.storageRoot, e.diskFilename), e.uploadedAt)
+		if os.IsNotExist(err) {
+			log.Printf("Warning: skipping image %d in export, file %s is missing", e.id, e.diskFilename)
+			continue
+		}
+		if err != nil {
+			log.Printf("Export aborted: error adding image %d: %v", e.id, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Export failed to finish archive: %v", err)
+	}
+}
+
+// exportEntry is the metadata needed to add one image to an export archive.
+type exportEntry struct {
+	id               int
+	originalFilename string
+	diskFilename     string
+	storageRoot      string
+	uploadedAt       time.Time
+}
+
+func loadExportEntries(r *http.Request) ([]exportEntry, error) {
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	query := "SELECT id, original_filename, disk_filename, COALESCE(storage_root, ''), uploaded_at FROM images"
+	condition, args := ownedImagesCondition(r, 0)
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+	rows, err := dbQuery(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []exportEntry
+	for rows.Next() {
+		var e exportEntry
+		if err := rows.Scan(&e.id, &e.originalFilename, &e.diskFilename, &e.storageRoot, &e.uploadedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// exportEntryName returns a unique archive entry name for an image, appending
+// the image ID when the original filename has already been used, and then a
+// counter should that name be another image's original filename.
+func exportEntryName(originalFilename string, id int, used map[string]bool) string {
+	name := filepath.Base(strings.ReplaceAll(originalFilename, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		name = fmt.Sprintf("image_%d", id)
+	}
+	if used[name] {
+		ext := filepath.Ext(name)
+		stem := fmt.Sprintf("%s_%d", strings.TrimSuffix(name, ext), id)
+		name = stem + ext
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d%s", stem, n, ext)
+		}
+	}
+	used[name] = true
+	return name
+}
+
+// writeExportEntry copies the stored image at path into the archive. Images
+// are already compressed, so entries are stored rather than deflated.
+func writeExportEntry(zw *zip.Writer, name, path string, modified time.Time) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, src)
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestExportEntryName(t *testing.T) {
+	type image struct {
+		originalFilename string
+		id               int
+	}
+	tests := []struct {
+		name   string
+		images []image
+		want   []string // Entry names in export order
+	}{
+		{"distinct names", []image{{"a.jpg", 1}, {"b.png", 2}}, []string{"a.jpg", "b.png"}},
+		{"repeated name gets the ID", []image{{"scan.jpg", 1}, {"scan.jpg", 7}, {"scan.jpg", 9}}, []string{"scan.jpg", "scan_7.jpg", "scan_9.jpg"}},
+		{"no extension", []image{{"scan", 1}, {"scan", 2}}, []string{"scan", "scan_2"}},
+		{"directories dropped", []image{{"../../etc/passwd", 1}, {"dir/sub/x.jpg", 2}}, []string{"passwd", "x.jpg"}},
+		{"Windows path", []image{{`C:\Users\me\x-ray.png`, 3}}, []string{"x-ray.png"}},
+		{"empty name", []image{{"", 4}}, []string{"image_4"}},
+		{"dot name", []image{{".", 5}}, []string{"image_5"}},
+		{"trailing slash", []image{{"folder/", 6}}, []string{"folder"}},
+		// The renamed entry must not collide with an original name.
+		{"renamed onto an existing name", []image{{"a_2.jpg", 1}, {"a.jpg", 3}, {"a.jpg", 2}}, []string{"a_2.jpg", "a.jpg", "a_2_2.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			used := make(map[string]bool)
+			for i, img := range tt.images {
+				if got := exportEntryName(img.originalFilename, img.id, used); got != tt.want[i] {
+					t.Errorf("exportEntryName(%q, %d) = %q, want %q", img.originalFilename, img.id, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// ContentTypeFacet counts the images of one content type.
+type ContentTypeFacet struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// TagFacet counts the images carrying one tag.
+type TagFacet struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// FacetsResponse is returned by GET /api/images/facets.
+type FacetsResponse struct {
+	ContentTypes []ContentTypeFacet `json:"content_types"`
+	Tags         []TagFacet         `json:"tags"`
+}
+
+// facetsHandler reports the content types and tags in use with their image
+// counts, for the SPA's filter sidebar. The counts cover the whole dataset,
+// whoever uploaded the images.
+func facetsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+
+	facets, err := loadFacets(ctx)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(facets)
+}
+
+func loadFacets(ctx context.Context) (FacetsResponse, error) {
+	facets := FacetsResponse{ContentTypes: []ContentTypeFacet{}, Tags: []TagFacet{}}
+
+	rows, err := dbQuery(ctx, `
+		SELECT content_type, COUNT(*) FROM images
+		WHERE content_type IS NOT NULL
+		GROUP BY content_type
+		ORDER BY COUNT(*) DESC, content_type`)
+	if err != nil {
+		return facets, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f ContentTypeFacet
+		if err := rows.Scan(&f.Type, &f.Count); err != nil {
+			return facets, err
+		}
+		facets.ContentTypes = append(facets.ContentTypes, f)
+	}
+	if err := rows.Err(); err != nil {
+		return facets, err
+	}
+
+	tagRows, err := dbQuery(ctx, `
+		SELECT t.name, COUNT(*) FROM image_tags it JOIN tags t ON t.id = it.tag_id
+		GROUP BY t.name
+		ORDER BY COUNT(*) DESC, t.name`)
+	if err != nil {
+		return facets, err
+	}
+	defer tagRows.Close()
+	for tagRows.Next() {
+		var f TagFacet
+		if err := tagRows.Scan(&f.Tag, &f.Count); err != nil {
+			return facets, err
+		}
+		facets.Tags = append(facets.Tags, f)
+	}
+	return facets, tagRows.Err()
+}
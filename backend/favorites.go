@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// favoriteImageHandler handles POST (star) and DELETE (unstar) on
+// /api/images/{id}/favorite for the authenticated caller. Both are
+// idempotent and answer 204.
+func favoriteImageHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST and DELETE methods are allowed")
+		return
+	}
+	p := principalFromContext(r.Context())
+	if p == nil {
+		writeError(w, http.StatusUnauthorized, "Favorites require an API key")
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	if r.Method == http.MethodDelete {
+		if _, err := dbExec(ctx, "DELETE FROM favorites WHERE user_id = $1 AND image_id = $2", p.ID, imageID); err != nil {
+			writeError(w, dbErrorStatus(err), "Error removing favorite: "+err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Selecting from images turns an unknown ID into zero rows rather than a
+	// foreign key violation.
+	result, err := dbExec(ctx,
+		"INSERT INTO favorites (user_id, image_id) SELECT $1, id FROM images WHERE id = $2 ON CONFLICT DO NOTHING",
+		p.ID, imageID,
+	)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error adding favorite: "+err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists bool
+		if err := dbQueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM images WHERE id = $1)", imageID).Scan(&exists); err != nil {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+			return
+		}
+		if !exists {
+			writeError(w, http.StatusNotFound, "Image not found")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// markFavorites sets IsFavorite on the images the caller has starred. It is
+// applied to responses rather than selected with imageColumns so that the
+// shared metadata cache stays free of per-user state. Anonymous callers have
+// no favorites.
+func markFavorites(ctx context.Context, r *http.Request, images []ImageMetadata) error {
+	p := principalFromContext(r.Context())
+	if p == nil || len(images) == 0 {
+		return nil
+	}
+	ids := make([]int, len(images))
+	for i, img := range images {
+		ids[i] = img.ID
+	}
+	rows, err := dbQuery(ctx, "SELECT image_id FROM favorites WHERE user_id = $1 AND image_id = ANY($2)", p.ID, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	starred := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		starred[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range images {
+		images[i].IsFavorite = starred[images[i].ID]
+	}
+	return nil
+}
+
+// favoritesOnlyFilter parses ?favorites_only= and, when true, returns a WHERE
+// condition limiting the list to the caller's favorites, using placeholder
+// argCount+1 for the caller's ID. ok is false if a response has been written.
+func favoritesOnlyFilter(w http.ResponseWriter, r *http.Request, argCount int) (condition string, arg any, ok bool) {
+	v := r.URL.Query().Get("favorites_only")
+	if v == "" {
+		return "", nil, true
+	}
+	only, err := strconv.ParseBool(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "favorites_only must be true or false")
+		return "", nil, false
+	}
+	if !only {
+		return "", nil, true
+	}
+	p := principalFromContext(r.Context())
+	if p == nil {
+		writeError(w, http.StatusUnauthorized, "favorites_only requires an API key")
+		return "", nil, false
+	}
+	return "id IN (SELECT image_id FROM favorites WHERE user_id = $" + strconv.Itoa(argCount+1) + ")", p.ID, true
+}
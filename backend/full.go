@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ImageDetailResponse is returned by GET /api/images/{id}/full: everything
+// the detail view shows, so it needs a single request.
+type ImageDetailResponse struct {
+	Image        ImageMetadata `json:"image"`                   // Includes tags and the caller's favorite status
+	OriginalURL  string        `json:"original_url,omitempty"`  // Signed when URL signing is configured; omitted then for anonymous callers
+	ThumbnailURL *string       `json:"thumbnail_url,omitempty"` // Nil while the image has no thumbnail, or as OriginalURL
+	ExpiresAt    *time.Time    `json:"expires_at,omitempty"`    // When a signed OriginalURL stops working
+}
+
+// imageDetailHandler serves GET /api/images/{id}/full. It is readable by the
+// same callers as GET /api/images/{id}; favorite status is the caller's own.
+func imageDetailHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	img, err := getImageMetadata(ctx, imageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	images := []ImageMetadata{img}
+	if err := markFavorites(ctx, r, images); err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying favorites: "+err.Error())
+		return
+	}
+
+	resp := ImageDetailResponse{Image: images[0]}
+	originalPath, thumbPath := "/api/images/file/"+img.DiskFilename, fmt.Sprintf("/api/images/%d/thumbnail", imageID)
+	switch {
+	case !urlSigningEnabled():
+		// Plain URLs work for everyone.
+	case principalFromContext(r.Context()) != nil:
+		expires := time.Now().Add(cfg.SignedURLTTL).Truncate(time.Second)
+		originalPath = signFileURL(img.DiskFilename, expires)
+		thumbPath += "?" + signedQuery(img.DiskFilename, expires)
+		resp.ExpiresAt = &expires
+		w.Header().Set("Cache-Control", "no-store")
+	default:
+		// Anonymous callers are not issued signed URLs, and unsigned ones
+		// would be refused.
+		originalPath, thumbPath = "", ""
+	}
+	if originalPath != "" {
+		resp.OriginalURL = externalURL(r, originalPath)
+	}
+	if img.ThumbFilename != nil && thumbPath != "" {
+		thumbURL := externalURL(r, thumbPath)
+		resp.ThumbnailURL = &thumbURL
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
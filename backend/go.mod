@@ -1,8 +1,16 @@
 module medical-image-backend
 
-go 1.21
+go 1.22
 
 require (
+	github.com/gen2brain/webp v0.4.5
 	github.com/google/uuid v1.5.0
 	github.com/lib/pq v1.10.9
+	golang.org/x/sync v0.6.0
+)
+
+require (
+	github.com/ebitengine/purego v0.7.1 // indirect
+	github.com/tetratelabs/wazero v1.7.3 // indirect
+	golang.org/x/sys v0.21.0 // indirect
 )
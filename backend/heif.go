@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// HEIC/HEIF images use HEVC compression, which no Go package decodes, so
+// decoding is delegated to an external converter named by HEIC_CONVERTER
+// (libheif's heif-convert; on Alpine, apk add libheif-tools). It is invoked
+// as "<converter> <input> <output.jpg>" and must write the primary image.
+// Dimensions are read from the container directly and need no converter.
+
+var heifContentTypes = []string{"image/heic", "image/heif"}
+
+const heifConvertTimeout = 30 * time.Second
+
+// heifBrands are the ftyp brands of HEIF still images.
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "mif1": true, "msf1": true,
+}
+
+const maxHEIFMetaSize = 1 << 20 // Larger meta boxes are not parsed
+
+// isHEIFFile reports whether the file at path starts with a HEIF ftyp box.
+func isHEIFFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var head [12]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil {
+		return false
+	}
+	return string(head[4:8]) == "ftyp" && heifBrands[string(head[8:12])]
+}
+
+// heifDimensions reads the image size from the ispe properties of a HEIF
+// file. A file carries one per item (tiles and thumbnails as well as the
+// primary image), and the largest is taken to be the primary. Rotation
+// applied at display time (irot) is not accounted for.
+func heifDimensions(path string) (width, height *int) {
+	if !isHEIFFile(path) {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+
+	meta, err := readTopLevelBox(f, "meta")
+	if err != nil || len(meta) < 4 {
+		return nil, nil
+	}
+	// meta is a full box: skip its version and flags.
+	iprp := findBox(meta[4:], "iprp")
+	ipco := findBox(iprp, "ipco")
+	var bestW, bestH int
+	for _, b := range splitBoxes(ipco) {
+		if b.typ != "ispe" || len(b.body) < 12 {
+			continue
+		}
+		w := int(binary.BigEndian.Uint32(b.body[4:8]))
+		h := int(binary.BigEndian.Uint32(b.body[8:12]))
+		if w*h > bestW*bestH {
+			bestW, bestH = w, h
+		}
+	}
+	if bestW == 0 || bestH == 0 {
+		return nil, nil
+	}
+	return &bestW, &bestH
+}
+
+type isoBox struct {
+	typ  string
+	body []byte
+}
+
+// splitBoxes splits data into the ISO BMFF boxes it contains, stopping at
+// the first malformed one.
+func splitBoxes(data []byte) []isoBox {
+	var boxes []isoBox
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size, header = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return boxes
+		}
+		boxes = append(boxes, isoBox{typ: string(data[4:8]), body: data[header:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+// findBox returns the body of the first box of type typ in data, or nil.
+func findBox(data []byte, typ string) []byte {
+	for _, b := range splitBoxes(data) {
+		if b.typ == typ {
+			return b.body
+		}
+	}
+	return nil
+}
+
+// readTopLevelBox scans the top-level boxes of f, skipping over others
+// (such as the media data) without reading them, and returns the body of the
+// first box of type typ.
+func readTopLevelBox(f *os.File, typ string) ([]byte, error) {
+	var offset int64
+	for {
+		var header [16]byte
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerLen := int64(8)
+		if size == 1 {
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			size, headerLen = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if size < headerLen {
+			// Includes size 0, a box running to the end of the file: never meta.
+			return nil, errors.New("box not found")
+		}
+		if string(header[4:8]) == typ {
+			if size-headerLen > maxHEIFMetaSize {
+				return nil, fmt.Errorf("%s box too large", typ)
+			}
+			body := make([]byte, size-headerLen)
+			if _, err := f.ReadAt(body, offset+headerLen); err != nil {
+				return nil, err
+			}
+			return body, nil
+		}
+		offset += size
+	}
+}
+
+// convertHEIFToJPEG runs HEIC_CONVERTER to write the primary image of the
+// HEIF file src as a JPEG at dst.
+func convertHEIFToJPEG(src, dst string) error {
+	if cfg.HEICConverter == "" {
+		return errors.New("HEIC_CONVERTER is not configured")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), heifConvertTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, cfg.HEICConverter, src, dst).CombinedOutput()
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("%s: %w: %s", cfg.HEICConverter, err, strings.TrimSpace(string(out)))
+	}
+	if _, err := os.Stat(dst); err != nil {
+		return fmt.Errorf("%s produced no output: %w", cfg.HEICConverter, err)
+	}
+	return nil
+}
+
+// decodeHEIFFile decodes a HEIF file by way of a temporary JPEG.
+func decodeHEIFFile(path string) (image.Image, error) {
+	tmp, err := os.CreateTemp("", "heif-*.jpg")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := convertHEIFToJPEG(path, tmp.Name()); err != nil {
+		return nil, err
+	}
+	return decodeImageFile(tmp.Name())
+}
+
+// heifToJPEGStep stores a JPEG transcoding of a HEIC upload when
+// HEIC_TRANSCODE is on, so browsers that cannot display HEIC still can; the
+// steps after it then see a JPEG. A failed transcoding keeps the original.
+func heifToJPEGStep(u *processedUpload) error {
+	if !cfg.HEICTranscode {
+		return nil
+	}
+	diskFilename, err := newDiskFilename(u.Root, ".jpg")
+	if err != nil {
+		return err
+	}
+	if err := convertHEIFToJPEG(u.path(), storagePath(u.Root, diskFilename)); err != nil {
+		log.Printf("Warning: HEIC transcoding of %s failed, storing original: %v", u.DiskFilename, err)
+		return nil
+	}
+	os.Remove(u.path())
+	u.DiskFilename = diskFilename
+	u.ContentType = "image/jpeg"
+	u.Size = sizeOnDisk(u.path())
+	return nil
+}
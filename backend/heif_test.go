@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// concat joins parts into a new slice.
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// box encodes an ISO BMFF box with a 32-bit size.
+func box(typ string, body ...[]byte) []byte {
+	content := concat(body...)
+	return concat(binary.BigEndian.AppendUint32(nil, uint32(8+len(content))), []byte(typ), content)
+}
+
+// ispe encodes an image spatial extents property.
+func ispe(width, height uint32) []byte {
+	body := make([]byte, 4, 12) // Version and flags
+	body = binary.BigEndian.AppendUint32(body, width)
+	body = binary.BigEndian.AppendUint32(body, height)
+	return box("ispe", body)
+}
+
+func TestSplitBoxes(t *testing.T) {
+	largeSize := concat(binary.BigEndian.AppendUint32(nil, 1), []byte("free"), binary.BigEndian.AppendUint64(nil, 18), []byte("ab"))
+
+	tests := []struct {
+		name  string
+		data  []byte
+		types []string
+		last  string // Body of the last box
+	}{
+		{"empty", nil, nil, ""},
+		{"shorter than a header", []byte{0, 0, 0}, nil, ""},
+		{"two boxes", concat(box("ftyp", []byte("heic")), box("meta", []byte("xy"))), []string{"ftyp", "meta"}, "xy"},
+		{"size zero runs to the end", concat(box("ftyp"), []byte{0, 0, 0, 0}, []byte("mdatrest")), []string{"ftyp", "mdat"}, "rest"},
+		{"64-bit size", largeSize, []string{"free"}, "ab"},
+		{"size beyond the data", concat(box("ftyp"), []byte{0, 0, 0, 99}, []byte("meta")), []string{"ftyp"}, ""},
+		{"size smaller than its header", []byte{0, 0, 0, 4, 'm', 'e', 't', 'a'}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			boxes := splitBoxes(tt.data)
+			if len(boxes) != len(tt.types) {
+				t.Fatalf("splitBoxes returned %d boxes, want %d", len(boxes), len(tt.types))
+			}
+			for i, b := range boxes {
+				if b.typ != tt.types[i] {
+					t.Errorf("box %d has type %q, want %q", i, b.typ, tt.types[i])
+				}
+			}
+			if len(boxes) > 0 && tt.last != "" {
+				if got := string(boxes[len(boxes)-1].body); got != tt.last {
+					t.Errorf("last box body = %q, want %q", got, tt.last)
+				}
+			}
+		})
+	}
+}
+
+func TestHEIFDimensions(t *testing.T) {
+	ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00mif1"))
+	meta := func(props ...[]byte) []byte {
+		return box("meta", []byte{0, 0, 0, 0}, box("iprp", box("ipco", props...)))
+	}
+
+	tests := []struct {
+		name          string
+		data          []byte
+		width, height int // Zero when no dimensions are expected
+	}{
+		{"primary image", concat(ftyp, meta(ispe(4032, 3024))), 4032, 3024},
+		{"largest of tile, thumbnail and primary", concat(ftyp, meta(ispe(512, 512), ispe(320, 240), ispe(4032, 3024))), 4032, 3024},
+		{"media data before meta", concat(ftyp, box("mdat", make([]byte, 64)), meta(ispe(100, 50))), 100, 50},
+		{"no ispe", concat(ftyp, meta(box("colr", []byte("nclx")))), 0, 0},
+		{"zero-sized ispe", concat(ftyp, meta(ispe(0, 0))), 0, 0},
+		{"no meta", ftyp, 0, 0},
+		{"not HEIF", concat(box("ftyp", []byte("isom")), meta(ispe(10, 10))), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "image.heic")
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			w, h := heifDimensions(path)
+			if tt.width == 0 {
+				if w != nil || h != nil {
+					t.Errorf("heifDimensions = %dx%d, want none", *w, *h)
+				}
+				return
+			}
+			if w == nil || h == nil {
+				t.Fatalf("heifDimensions = none, want %dx%d", tt.width, tt.height)
+			}
+			if *w != tt.width || *h != tt.height {
+				t.Errorf("heifDimensions = %dx%d, want %dx%d", *w, *h, tt.width, tt.height)
+			}
+		})
+	}
+}
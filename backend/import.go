@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxImportEntries = 1000 // Manifest entries per request; larger datasets are imported in batches
+
+// Outcomes of a manifest entry.
+const (
+	importImported = "imported"
+	importSkipped  = "skipped" // Already recorded, by disk_filename or content
+	importInvalid  = "invalid" // Failed validation; nothing recorded
+)
+
+// ImportRequest is the body of POST /api/admin/import.
+type ImportRequest struct {
+	Entries []ImportEntry `json:"entries"`
+}
+
+// ImportEntry describes one file already placed in an upload root.
+type ImportEntry struct {
+	DiskFilename     string `json:"disk_filename"` // Slash-separated path relative to the root
+	OriginalFilename string `json:"original_filename"`
+	ContentType      string `json:"content_type"`
+	Size             int64  `json:"size"`                   // Must match the file on disk
+	StorageRoot      string `json:"storage_root,omitempty"` // One of UPLOAD_PATHS; the default upload directory if empty
+}
+
+// ImportResult is the outcome of one manifest entry, in manifest order.
+type ImportResult struct {
+	DiskFilename string `json:"disk_filename"`
+	Status       string `json:"status"`
+	ID           int    `json:"id,omitempty"`     // New image, or for skipped entries the existing one
+	Reason       string `json:"reason,omitempty"` // Why the entry was skipped or is invalid
+}
+
+// ImportResponse is returned by POST /api/admin/import.
+type ImportResponse struct {
+	Imported int            `json:"imported"`
+	Skipped  int            `json:"skipped"`
+	Invalid  int            `json:"invalid"`
+	Results  []ImportResult `json:"results"`
+}
+
+// importCandidate is a validated entry waiting to be inserted.
+type importCandidate struct {
+	index         int
+	entry         ImportEntry
+	root          string
+	hash          string
+	width, height *int
+}
+
+// importHandler handles POST /api/admin/import, recording files that were
+// copied onto the volume directly, for migrations too large to go through
+// the upload endpoint. Every file is checked and hashed first; the rows are
+// then inserted in one transaction. Thumbnails and perceptual hashes are
+// left to the prewarm worker and the backfill job.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+	var req ImportRequest
+	if !decodeJSONBody(w, r, &req, "entries") {
+		return
+	}
+	if len(req.Entries) == 0 || len(req.Entries) > maxImportEntries {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("entries must list between 1 and %d files", maxImportEntries))
+		return
+	}
+
+	// Hashing a large batch can take far longer than the server-wide write
+	// timeout.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Warning: could not clear import write deadline: %v", err)
+	}
+
+	resp := ImportResponse{Results: make([]ImportResult, len(req.Entries))}
+	var candidates []importCandidate
+	seen := make(map[string]bool)
+	for i, e := range req.Entries {
+		resp.Results[i] = ImportResult{DiskFilename: e.DiskFilename, Status: importInvalid}
+		c, reason := checkImportEntry(e)
+		if reason == "" && seen[c.hash] {
+			reason = "same content as an earlier entry"
+		}
+		if reason != "" {
+			resp.Results[i].Reason = reason
+			continue
+		}
+		seen[c.hash] = true
+		c.index = i
+		candidates = append(candidates, c)
+	}
+
+	var owner sql.NullString
+	if p := principalFromContext(r.Context()); p != nil {
+		owner = sql.NullString{String: p.ID, Valid: true}
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	tx, err := dbBegin(ctx)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error starting transaction: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	for _, c := range candidates {
+		res := &resp.Results[c.index]
+		var existingID int
+		err := tx.QueryRowContext(ctx,
+			"SELECT id FROM images WHERE disk_filename = $1 OR (COALESCE(owner, '') = $3 AND content_sha256 = $2) LIMIT 1",
+			c.entry.DiskFilename, c.hash, owner.String,
+		).Scan(&existingID)
+		if err == nil {
+			res.Status, res.ID, res.Reason = importSkipped, existingID, "already recorded"
+			continue
+		}
+		if err != sql.ErrNoRows {
+			writeError(w, dbErrorStatus(err), "Error checking for existing image: "+err.Error())
+			return
+		}
+		// ON CONFLICT covers an upload of the same file committed meanwhile.
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO images (original_filename, disk_filename, content_type, size, content_sha256, width, height, storage_root, owner)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT DO NOTHING RETURNING id`,
+			c.entry.OriginalFilename, c.entry.DiskFilename, c.entry.ContentType, c.entry.Size, c.hash, c.width, c.height, c.root, owner,
+		).Scan(&res.ID)
+		if err == sql.ErrNoRows {
+			res.Status, res.Reason = importSkipped, "already recorded"
+			continue
+		}
+		if err != nil {
+			writeError(w, dbErrorStatus(err), "Error saving image metadata to database: "+err.Error())
+			return
+		}
+		res.Status = importImported
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error committing import: "+err.Error())
+		return
+	}
+
+	for _, res := range resp.Results {
+		switch res.Status {
+		case importImported:
+			resp.Imported++
+			publishImageEvent(eventImageUploaded, res.ID, res.DiskFilename)
+		case importSkipped:
+			resp.Skipped++
+		default:
+			resp.Invalid++
+		}
+	}
+	log.Printf("Import: %d imported, %d skipped, %d invalid", resp.Imported, resp.Skipped, resp.Invalid)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkImportEntry validates a manifest entry against the file it names,
+// returning the candidate to insert or a reason it cannot be imported.
+func checkImportEntry(e ImportEntry) (importCandidate, string) {
+	c := importCandidate{entry: e, root: uploadPath}
+	if e.StorageRoot != "" {
+		if !slices.Contains(uploadRoots(), e.StorageRoot) {
+			return c, "storage_root is not a configured upload root"
+		}
+		c.root = e.StorageRoot
+	}
+	name := e.DiskFilename
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") || strings.Contains(name, "\\") {
+		return c, "disk_filename must be a clean relative path"
+	}
+	if first, _, _ := strings.Cut(name, "/"); c.root == uploadPath && (first == thumbnailDir || first == derivedDir) {
+		return c, "disk_filename is inside a generated-files directory"
+	}
+	if e.OriginalFilename == "" || utf8.RuneCountInString(e.OriginalFilename) > maxOriginalFilenameLength {
+		return c, fmt.Sprintf("original_filename is required and must be at most %d characters", maxOriginalFilenameLength)
+	}
+	if !slices.Contains(cfg.AllowedContentTypes, e.ContentType) {
+		return c, fmt.Sprintf("content_type %q is not allowed", e.ContentType)
+	}
+
+	p := storagePath(c.root, name)
+	info, err := os.Stat(p)
+	if err != nil {
+		return c, "file not found"
+	}
+	if !info.Mode().IsRegular() {
+		return c, "not a regular file"
+	}
+	if info.Size() != e.Size {
+		return c, fmt.Sprintf("size is %d bytes on disk, not %d", info.Size(), e.Size)
+	}
+	if msg := validateUploadedFile(p, e.ContentType, info.Size(), e.Size); msg != "" {
+		return c, msg
+	}
+	if msg := resolutionError(p); msg != "" {
+		return c, msg
+	}
+
+	hash, err := fileSHA256(p)
+	if err != nil {
+		return c, "file cannot be read"
+	}
+	c.hash = hash
+	c.width, c.height = imageDimensions(p)
+	return c, ""
+}
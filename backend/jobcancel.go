@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+)
+
+// trainingSubmissions holds the cancel functions of trainer submissions in
+// progress, so cancelling a job also stops its retries. Simulated jobs need
+// no entry: the simulator only advances jobs whose status is still pending
+// or running, so the status change alone stops them at the next step.
+var trainingSubmissions = &submissionRegistry{cancels: make(map[int]context.CancelFunc)}
+
+type submissionRegistry struct {
+	mu      sync.Mutex
+	cancels map[int]context.CancelFunc
+}
+
+// Track derives a cancellable context for submitting jobID. The caller must
+// call the returned function once the submission is over.
+func (s *submissionRegistry) Track(parent context.Context, jobID int) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	s.mu.Lock()
+	s.cancels[jobID] = cancel
+	s.mu.Unlock()
+	return ctx, func() {
+		s.mu.Lock()
+		delete(s.cancels, jobID)
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// Cancel stops the submission of jobID, if one is in progress.
+func (s *submissionRegistry) Cancel(jobID int) {
+	s.mu.Lock()
+	cancel := s.cancels[jobID]
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// cancelTrainingJobHandler handles DELETE /api/ml/jobs/{id}. Pending and
+// running jobs are marked cancelled and the updated job returned; any other
+// status is final (a submitted job belongs to the trainer at TRAINER_URL,
+// which offers no way to cancel it), giving 409. Only the principal that
+// started a job, or an admin, may cancel it; see mayModifyImage.
+func cancelTrainingJobHandler(w http.ResponseWriter, r *http.Request, jobID int) {
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var owner sql.NullString
+	if err := dbQueryRow(ctx, "SELECT owner FROM training_jobs WHERE id = $1", jobID).Scan(&owner); err != nil {
+		writeTrainingJobError(w, err)
+		return
+	}
+	if !mayModifyImage(r, owner) {
+		writeError(w, http.StatusForbidden, "Only the principal that started this training job or an admin may cancel it")
+		return
+	}
+
+	var status string
+	err := dbQueryRow(ctx, `
+		UPDATE training_jobs SET status = $1
+		WHERE id = $2 AND status IN ($3, $4)
+		RETURNING status`,
+		trainingStatusCancelled, jobID, trainingStatusPending, trainingStatusRunning,
+	).Scan(&status)
+	if err == sql.ErrNoRows {
+		job, err := loadTrainingJob(ctx, jobID)
+		if err != nil {
+			writeTrainingJobError(w, err)
+			return
+		}
+		writeError(w, http.StatusConflict, "Training job is already "+job.Status+" and cannot be cancelled")
+		return
+	}
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error updating training job: "+err.Error())
+		return
+	}
+	trainingSubmissions.Cancel(jobID)
+	trainingJobChanges.Notify(jobID)
+
+	job, err := loadTrainingJob(ctx, jobID)
+	if err != nil {
+		writeTrainingJobError(w, err)
+		return
+	}
+	writeTrainingJob(w, job)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Background job states.
+const (
+	jobStatusRunning   = "running"
+	jobStatusSucceeded = "succeeded"
+	jobStatusFailed    = "failed"
+)
+
+// BackgroundJob tracks a long-running admin task started by a request that
+// returns before the work finishes.
+type BackgroundJob struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// jobRegistry holds background jobs in memory; they do not survive a restart.
+// Finished jobs are dropped by the purge worker after JOB_RETENTION.
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*BackgroundJob
+	ctx  context.Context // Given to every job; main replaces it with one that ends at shutdown
+}
+
+var backgroundJobs = &jobRegistry{jobs: make(map[string]*BackgroundJob), ctx: context.Background()}
+
+// Start registers a job of the given type and runs work in a goroutine. The
+// work gets the registry's context, not the starting request's, so it
+// outlives the request but stops at shutdown. The update callback passed to
+// work applies changes to the job under the lock.
+func (reg *jobRegistry) Start(jobType string, work func(ctx context.Context, update func(func(*BackgroundJob))) error) BackgroundJob {
+	job := &BackgroundJob{
+		ID:        uuid.New().String(),
+		Type:      jobType,
+		Status:    jobStatusRunning,
+		StartedAt: time.Now(),
+	}
+	reg.mu.Lock()
+	reg.jobs[job.ID] = job
+	snapshot := *job
+	reg.mu.Unlock()
+
+	update := func(fn func(*BackgroundJob)) {
+		reg.mu.Lock()
+		defer reg.mu.Unlock()
+		fn(job)
+	}
+	go func() {
+		err := work(reg.ctx, update)
+		update(func(j *BackgroundJob) {
+			now := time.Now()
+			j.FinishedAt = &now
+			j.Status = jobStatusSucceeded
+			if err != nil {
+				j.Status = jobStatusFailed
+				j.Error = err.Error()
+			}
+		})
+	}()
+	return snapshot
+}
+
+// Get returns a copy of the job with the given ID.
+func (reg *jobRegistry) Get(id string) (BackgroundJob, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	job, ok := reg.jobs[id]
+	if !ok {
+		return BackgroundJob{}, false
+	}
+	return *job, true
+}
+
+// PurgeFinished removes jobs that finished before cutoff and returns how many
+// were removed. Running jobs are always kept.
+func (reg *jobRegistry) PurgeFinished(cutoff time.Time) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	removed := 0
+	for id, job := range reg.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(reg.jobs, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// jobStatusHandler handles GET /api/admin/jobs/{id}.
+func jobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+	job, ok := backgroundJobs.Get(strings.TrimPrefix(r.URL.Path, "/api/admin/jobs/"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// writeJobAccepted responds 202 with the job and where to poll its status.
+func writeJobAccepted(w http.ResponseWriter, r *http.Request, job BackgroundJob) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", externalURL(r, "/api/admin/jobs/"+job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
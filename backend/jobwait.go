@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// trainingWaitRecheck is how often a long-poll rereads the job while
+// waiting, to see changes written by the trainer or another instance, which
+// do not pass through trainingJobChanges.
+const trainingWaitRecheck = 2 * time.Second
+
+// jobChangeNotifier wakes goroutines waiting for a training job to change.
+// Each job with waiters has a channel that is closed, and forgotten, on the
+// job's next change. It is safe for concurrent use.
+type jobChangeNotifier struct {
+	mu      sync.Mutex
+	waiting map[int]chan struct{}
+}
+
+var trainingJobChanges = &jobChangeNotifier{waiting: make(map[int]chan struct{})}
+
+// Wait returns a channel closed at the job's next change.
+func (n *jobChangeNotifier) Wait(jobID int) <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ch, ok := n.waiting[jobID]
+	if !ok {
+		ch = make(chan struct{})
+		n.waiting[jobID] = ch
+	}
+	return ch
+}
+
+// Notify wakes everyone waiting on the job.
+func (n *jobChangeNotifier) Notify(jobID int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ch, ok := n.waiting[jobID]; ok {
+		close(ch)
+		delete(n.waiting, jobID)
+	}
+}
+
+// waitTrainingJobHandler handles GET /api/ml/jobs/{id}/wait?since=<status>.
+// It answers as soon as the job's status differs from since, or with the
+// unchanged job after TRAINING_WAIT_TIMEOUT, so clients without WebSockets
+// can follow a job by calling it in a loop. Without since it answers at once.
+func waitTrainingJobHandler(w http.ResponseWriter, r *http.Request, jobID int) {
+	since := r.URL.Query().Get("since")
+	deadline := time.NewTimer(cfg.TrainingWaitTimeout)
+	defer deadline.Stop()
+	recheck := time.NewTicker(trainingWaitRecheck)
+	defer recheck.Stop()
+
+	for {
+		// Subscribe before reading so a change in between is not missed.
+		changed := trainingJobChanges.Wait(jobID)
+		ctx, cancel := dbContext(r.Context())
+		job, err := loadTrainingJob(ctx, jobID)
+		cancel()
+		if err != nil {
+			if err == sql.ErrNoRows {
+				// Drop the channel so unknown IDs do not accumulate.
+				trainingJobChanges.Notify(jobID)
+			}
+			writeTrainingJobError(w, err)
+			return
+		}
+		if since == "" || job.Status != since {
+			writeTrainingJob(w, job)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-recheck.C:
+		case <-deadline.C:
+			writeTrainingJob(w, job)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
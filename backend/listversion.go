@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// imageListChangedAt returns when images or their tags last changed. The
+// timestamp is maintained by triggers on both tables, so every write path
+// is covered without the handlers having to remember to bump it.
+func imageListChangedAt(ctx context.Context) (time.Time, error) {
+	var changedAt time.Time
+	err := dbQueryRow(ctx, "SELECT changed_at FROM image_list_state").Scan(&changedAt)
+	return changedAt, err
+}
+
+// listVersion is the precise form of changedAt sent as X-List-Version.
+func listVersion(changedAt time.Time) string {
+	return strconv.FormatInt(changedAt.UnixMicro(), 10)
+}
+
+// listNotModified reports whether the client's copy of the list is current,
+// judged by X-List-Version when sent and by If-Modified-Since otherwise.
+// If-Modified-Since has one-second resolution, so X-List-Version is
+// preferred by clients that poll often.
+func listNotModified(r *http.Request, changedAt time.Time) bool {
+	if v := r.Header.Get("X-List-Version"); v != "" {
+		return v == listVersion(changedAt)
+	}
+	if v := r.Header.Get("If-Modified-Since"); v != "" {
+		since, err := http.ParseTime(v)
+		return err == nil && !changedAt.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+func setListVersionHeaders(w http.ResponseWriter, changedAt time.Time) {
+	w.Header().Set("Last-Modified", changedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("X-List-Version", listVersion(changedAt))
+	w.Header().Set("Cache-Control", "no-cache")
+}
@@ -1,24 +1,43 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
-	"github.com/google/uuid" // For generating unique filenames
-	_ "github.com/lib/pq"    // PostgreSQL driver
+	"github.com/lib/pq" // PostgreSQL driver
+	"golang.org/x/sync/semaphore"
 )
 
 const uploadPath = "/app/uploads" // Ensure this matches docker-compose volume mount
 
+const shutdownTimeout = 30 * time.Second // Grace period for in-flight requests on SIGTERM
+
+const maxUploadSize = 10 << 20 // Max request body size for uploads (10 MB)
+
+const multipartMaxMemory = 1 << 20 // Upload bytes parsed into memory; the rest spills to TEMP_DIR
+
 // ImageMetadata struct for database records and API responses
 type ImageMetadata struct {
 	ID               int       `json:"id"`
@@ -26,55 +45,141 @@ type ImageMetadata struct {
 	DiskFilename     string    `json:"disk_filename"` // Actual filename on disk (e.g., UUID.ext)
 	ContentType      string    `json:"content_type"`
 	Size             int64     `json:"size"`
+	Width            *int      `json:"width,omitempty"`          // Pixel width, nil if the file is not a decodable image
+	Height           *int      `json:"height,omitempty"`         // Pixel height, nil if the file is not a decodable image
+	ThumbFilename    *string   `json:"thumb_filename,omitempty"` // Generated thumbnail under uploadPath/thumbnails
 	UploadedAt       time.Time `json:"uploaded_at"`
+	Tags             []string  `json:"tags"`
+	ExifStripped     bool      `json:"exif_stripped"`      // Metadata was removed on upload (STRIP_EXIF)
+	AlbumID          *int      `json:"album_id,omitempty"` // Album the image belongs to, if any
+	IsFavorite       bool      `json:"is_favorite"`        // Starred by the caller; set per response, never cached
+	StorageRoot      string    `json:"-"`                  // Root directory of DiskFilename; "" for uploadPath
+}
+
+// imageColumns is the column list scanned by scanImage. Tags are aggregated
+// per image so list and single-image queries need no extra round trips.
+const imageColumns = `id, original_filename, disk_filename, content_type, size, width, height, thumb_filename, uploaded_at,
+	COALESCE((SELECT array_agg(t.name ORDER BY t.name) FROM image_tags it JOIN tags t ON t.id = it.tag_id WHERE it.image_id = images.id), '{}'),
+	COALESCE(storage_root, ''), exif_stripped, album_id`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanImage scans a row selected with imageColumns.
+func scanImage(row rowScanner) (ImageMetadata, error) {
+	var img ImageMetadata
+	err := row.Scan(imageScanDest(&img)...)
+	return img, err
 }
 
+// imageScanDest returns the scan destinations for imageColumns, for queries
+// that select further columns after them.
+func imageScanDest(img *ImageMetadata) []any {
+	return []any{&img.ID, &img.OriginalFilename, &img.DiskFilename, &img.ContentType, &img.Size, &img.Width, &img.Height, &img.ThumbFilename, &img.UploadedAt, pq.Array(&img.Tags), &img.StorageRoot, &img.ExifStripped, &img.AlbumID}
+}
+
+// getImageMetadata loads a single image by ID, returning sql.ErrNoRows if it
+// does not exist.
+func getImageMetadata(ctx context.Context, id int) (ImageMetadata, error) {
+	return scanImage(dbQueryRow(ctx, "SELECT "+imageColumns+" FROM images WHERE id = $1", id))
+}
+
+// UpdateImageRequest is the body of PATCH /api/images/{id}.
+type UpdateImageRequest struct {
+	OriginalFilename string `json:"original_filename"`
+}
+
+const maxOriginalFilenameLength = 255 // Matches the images.original_filename column
+
 // SimpleResponse struct for simple JSON messages
 type SimpleResponse struct {
-	Message string `json:"message,omitempty"`
-	Error   string `json:"error,omitempty"`
-	ID      int    `json:"id,omitempty"` // Optionally return ID of new resource
+	Message   string       `json:"message,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Code      string       `json:"code,omitempty"`      // Machine-readable form of Error, e.g. "not_found"
+	Details   []FieldError `json:"details,omitempty"`   // Per-field problems when Code is "validation_failed"
+	Retryable *bool        `json:"retryable,omitempty"` // Set on errors: whether repeating the request may succeed; see Retry-After
+	ID        int          `json:"id,omitempty"`        // Optionally return ID of new resource
+	FileURL   string       `json:"file_url,omitempty"`  // Optionally return where the new file is served
+
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty"` // Hex SHA-256 of the bytes received for an upload
 }
 
 var db *sql.DB // Global database connection pool
 
+// sortableColumns maps the accepted ?sort= values to their database columns,
+// or to the expression sorted on where there is no column.
+var sortableColumns = map[string]string{
+	"uploaded_at":       "uploaded_at",
+	"size":              "size",
+	"original_filename": "original_filename",
+	"popularity":        "COALESCE((SELECT count FROM image_downloads WHERE image_id = images.id), 0)",
+}
+
+// sortOrders maps the accepted ?order= values to SQL sort directions.
+var sortOrders = map[string]string{
+	"asc":  "ASC",
+	"desc": "DESC",
+}
+
 func main() {
-	// Ensure upload directory exists
-	if err := os.MkdirAll(uploadPath, os.ModePerm); err != nil {
-		log.Fatalf("Failed to create upload directory: %v", err)
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
+	cfg = config
 
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
+	dbBreaker = &circuitBreaker{threshold: cfg.DBBreakerThreshold, cooldown: cfg.DBBreakerCooldown}
+	uploadSlots = semaphore.NewWeighted(int64(cfg.MaxConcurrentUploads))
+	imageProcessing = newProcessingPool(cfg.ProcessingWorkers)
+	if cfg.MetadataCacheSize > 0 {
+		imageCache = newMetadataCache(cfg.MetadataCacheSize, cfg.MetadataCacheTTL)
+	}
+	log.Printf("Encoding thumbnails at quality %d and converted images at quality %d", cfg.ThumbQuality, cfg.ConvertQuality)
+	if cfg.ModerationURL != "" {
+		moderator = httpModerator{url: cfg.ModerationURL, client: &http.Client{Timeout: cfg.ModerationTimeout}}
+		log.Printf("Uploads are moderated by %s", cfg.ModerationURL)
+	}
+	if cfg.ClamAVAddr != "" {
+		virusScanner = clamdScanner{addr: cfg.ClamAVAddr, timeout: cfg.ClamAVTimeout}
+		log.Printf("Uploads are scanned for malware by clamd at %s", cfg.ClamAVAddr)
+	}
+	if cfg.TrainerURL != "" {
+		trainer = httpTrainer{url: cfg.TrainerURL, client: &http.Client{Timeout: cfg.TrainerTimeout}}
+		log.Printf("Training jobs are submitted to %s", cfg.TrainerURL)
+	}
+	apiKeys = newAPIKeys(cfg.APIKeys)
+	if len(apiKeys) == 0 {
+		log.Printf("Warning: API_KEYS is not set; admin routes will reject every request")
+	}
+	maintenanceMode.Store(cfg.MaintenanceMode)
+	if cfg.MaintenanceMode {
+		log.Printf("Starting in read-only maintenance mode")
+	}
+	if cfg.EventWebhookURL != "" {
+		events = newWebhookSink(cfg.EventWebhookURL, cfg.EventWebhookTimeout)
+	}
 
-	var err error
-	maxRetries := 10
-	for i := 0; i < maxRetries; i++ {
-		db, err = sql.Open("postgres", connStr)
-		if err != nil {
-			log.Printf("Failed to open database connection: %v. Retrying in 5 seconds...", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-		err = db.Ping()
-		if err == nil {
-			log.Println("Successfully connected to the database!")
-			break
+	// Ensure upload roots, thumbnail and derived image directories exist
+	dirs := []string{filepath.Join(uploadPath, thumbnailDir), filepath.Join(uploadPath, derivedDir)}
+	for _, dir := range append(dirs, cfg.UploadPaths...) {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			log.Fatalf("Failed to create upload directory: %v", err)
 		}
-		log.Printf("Failed to ping database: %v. Retrying in 5 seconds...", err)
-		db.Close() // Close previous attempt before retrying
-		time.Sleep(5 * time.Second)
+	}
+	if err := useTempDir(cfg.TempDir); err != nil {
+		log.Fatalf("Failed to set up TEMP_DIR %s: %v", cfg.TempDir, err)
 	}
 
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
+
+	db, err = connectDB(connStr)
 	if err != nil {
-		log.Fatalf("Could not connect to the database after %d retries: %v", maxRetries, err)
+		log.Fatalf("Could not connect to the database after %d retries: %v", cfg.DBConnectMaxRetries, err)
 	}
+	log.Println("Successfully connected to the database!")
 	// defer db.Close() // Keep db open for handlers
 
 	// Create table if not exists
@@ -93,210 +198,1420 @@ func main() {
 	}
 	log.Println("Images table checked/created.")
 
-	// API Router
+	// Content hash used to detect duplicate uploads; it is made unique per
+	// owner once the owner column exists, below.
+	_, err = db.Exec(`ALTER TABLE images ADD COLUMN IF NOT EXISTS content_sha256 VARCHAR(64)`)
+	if err != nil {
+		log.Fatalf("Failed to add content_sha256 column: %v", err)
+	}
+
+	// Supports keyset pagination of the image list in upload order.
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS images_uploaded_at_id_idx ON images (uploaded_at, id)`)
+	if err != nil {
+		log.Fatalf("Failed to create images_uploaded_at_id_idx: %v", err)
+	}
+
+	_, err = db.Exec(`
+		ALTER TABLE images ADD COLUMN IF NOT EXISTS width INTEGER;
+		ALTER TABLE images ADD COLUMN IF NOT EXISTS height INTEGER;
+		ALTER TABLE images ADD COLUMN IF NOT EXISTS thumb_filename VARCHAR(255);
+		ALTER TABLE images ADD COLUMN IF NOT EXISTS phash BIGINT;
+		ALTER TABLE images ADD COLUMN IF NOT EXISTS storage_root TEXT;
+		ALTER TABLE images ADD COLUMN IF NOT EXISTS exif_stripped BOOLEAN NOT NULL DEFAULT FALSE;
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add image dimension columns: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS tags (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(64) NOT NULL UNIQUE
+		);
+		CREATE TABLE IF NOT EXISTS image_tags (
+			image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+			tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (image_id, tag_id)
+		);
+		CREATE INDEX IF NOT EXISTS image_tags_tag_id_idx ON image_tags (tag_id);
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create tag tables: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS training_jobs (
+			id SERIAL PRIMARY KEY,
+			model_name VARCHAR(255) NOT NULL,
+			epochs INTEGER NOT NULL,
+			status VARCHAR(32) NOT NULL DEFAULT 'pending',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS training_job_images (
+			job_id INTEGER NOT NULL REFERENCES training_jobs(id) ON DELETE CASCADE,
+			image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+			PRIMARY KEY (job_id, image_id)
+		);
+		CREATE TABLE IF NOT EXISTS training_job_attempts (
+			job_id INTEGER NOT NULL REFERENCES training_jobs(id) ON DELETE CASCADE,
+			attempt INTEGER NOT NULL,
+			started_at TIMESTAMP NOT NULL,
+			duration_ms BIGINT NOT NULL,
+			status_code INTEGER,
+			error TEXT,
+			PRIMARY KEY (job_id, attempt)
+		);
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create training job tables: %v", err)
+	}
+	_, err = db.Exec(`ALTER TABLE training_jobs ADD COLUMN IF NOT EXISTS progress INTEGER NOT NULL DEFAULT 0`)
+	if err != nil {
+		log.Fatalf("Failed to add training job progress column: %v", err)
+	}
+	// The principal that started each job, checked before it is cancelled.
+	// NULL for anonymous jobs and for jobs started before this column
+	// existed.
+	_, err = db.Exec(`ALTER TABLE training_jobs ADD COLUMN IF NOT EXISTS owner VARCHAR(255)`)
+	if err != nil {
+		log.Fatalf("Failed to add training job owner column: %v", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS model_artifacts (
+			id SERIAL PRIMARY KEY,
+			job_id INTEGER NOT NULL REFERENCES training_jobs(id) ON DELETE CASCADE,
+			location TEXT NOT NULL,
+			metrics JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS model_artifacts_job_id_idx ON model_artifacts (job_id);
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create model_artifacts table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id SERIAL PRIMARY KEY,
+			action VARCHAR(32) NOT NULL,
+			actor VARCHAR(255),
+			image_ids INTEGER[] NOT NULL,
+			client_ip VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create audit_log table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS albums (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL UNIQUE,
+			owner VARCHAR(255),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		ALTER TABLE images ADD COLUMN IF NOT EXISTS album_id INTEGER REFERENCES albums(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS images_album_id_idx ON images (album_id);
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create albums table: %v", err)
+	}
+
+	// Download counts live outside images so that flushing them neither
+	// contends with image updates nor bumps the list version.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS image_downloads (
+			image_id INTEGER PRIMARY KEY REFERENCES images(id) ON DELETE CASCADE,
+			count BIGINT NOT NULL DEFAULT 0,
+			last_downloaded_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS image_downloads_count_idx ON image_downloads (count DESC);
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create image_downloads table: %v", err)
+	}
+
+	// Favorites are per principal; user_id holds the Principal ID.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS favorites (
+			user_id VARCHAR(255) NOT NULL,
+			image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, image_id)
+		);
+		CREATE INDEX IF NOT EXISTS favorites_image_id_idx ON favorites (image_id);
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create favorites table: %v", err)
+	}
+
+	// Tombstones for incremental sync. A row-level trigger catches every
+	// deletion path; seq gives clients a cursor that only moves forward.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS image_deletions (
+			seq BIGSERIAL PRIMARY KEY,
+			image_id INTEGER NOT NULL,
+			deleted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		-- Size and upload time feed GET /api/admin/usage-history; they are
+		-- NULL for deletions recorded before they were added.
+		ALTER TABLE image_deletions ADD COLUMN IF NOT EXISTS size BIGINT;
+		ALTER TABLE image_deletions ADD COLUMN IF NOT EXISTS uploaded_at TIMESTAMP;
+		-- POST /api/images/validate looks deletions up by image.
+		CREATE INDEX IF NOT EXISTS image_deletions_image_id_idx ON image_deletions (image_id);
+
+		CREATE OR REPLACE FUNCTION record_image_deletion() RETURNS trigger AS $$
+		BEGIN
+			INSERT INTO image_deletions (image_id, size, uploaded_at) VALUES (OLD.id, OLD.size, OLD.uploaded_at);
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS images_record_deletion ON images;
+		CREATE TRIGGER images_record_deletion AFTER DELETE ON images
+			FOR EACH ROW EXECUTE FUNCTION record_image_deletion();
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create image deletion tracking: %v", err)
+	}
+
+	// The principal that uploaded each image, which mayModifyImage checks
+	// before an image is changed or deleted and which scopes exports,
+	// training and validation. NULL for anonymous uploads and for images
+	// uploaded before this column existed.
+	_, err = db.Exec(`ALTER TABLE images ADD COLUMN IF NOT EXISTS owner VARCHAR(255)`)
+	if err != nil {
+		log.Fatalf("Failed to add owner column: %v", err)
+	}
+
+	// Duplicates are detected within one owner's images, anonymous uploads
+	// counting as one owner, so an upload never resolves to an image its
+	// uploader cannot modify or reveals that someone else holds the same
+	// file. Rows created before content_sha256 existed stay NULL, which the
+	// unique index ignores. The index replaces a global one.
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS images_owner_content_sha256_key ON images (COALESCE(owner, ''), content_sha256);
+		DROP INDEX IF EXISTS images_content_sha256_key;
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create images_owner_content_sha256_key: %v", err)
+	}
+
+	// A single row recording when the image list last changed, bumped by
+	// statement-level triggers so list polling can answer 304 cheaply.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS image_list_state (
+			id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+			changed_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+		);
+		INSERT INTO image_list_state DEFAULT VALUES ON CONFLICT DO NOTHING;
+
+		CREATE OR REPLACE FUNCTION touch_image_list() RETURNS trigger AS $$
+		BEGIN
+			UPDATE image_list_state SET changed_at = clock_timestamp();
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS images_touch_list ON images;
+		CREATE TRIGGER images_touch_list AFTER INSERT OR UPDATE OR DELETE ON images
+			FOR EACH STATEMENT EXECUTE FUNCTION touch_image_list();
+		DROP TRIGGER IF EXISTS image_tags_touch_list ON image_tags;
+		CREATE TRIGGER image_tags_touch_list AFTER INSERT OR UPDATE OR DELETE ON image_tags
+			FOR EACH STATEMENT EXECUTE FUNCTION touch_image_list();
+		-- The list carries is_favorite, so a caller's own star must not be
+		-- hidden behind a 304.
+		DROP TRIGGER IF EXISTS favorites_touch_list ON favorites;
+		CREATE TRIGGER favorites_touch_list AFTER INSERT OR UPDATE OR DELETE ON favorites
+			FOR EACH STATEMENT EXECUTE FUNCTION touch_image_list();
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create image list change tracking: %v", err)
+	}
+
+	// API Router. Routes taking JSON bodies are capped at MAX_JSON_BODY_BYTES;
+	// the upload route applies its own, larger limit.
 	mux := http.NewServeMux()
+	jsonLimit := int64(cfg.MaxJSONBodyBytes)
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "Hello from Go Backend!")
-	})
+	mux.HandleFunc("/", rootHandler())
 	mux.HandleFunc("/health", healthCheckHandler)
+	mux.HandleFunc("/version", versionHandler)
+	mux.HandleFunc("/api/stats", requireAdmin(statsHandler))
+	mux.Handle("/metrics", expvar.Handler())
+	mux.HandleFunc("/api/openapi.json", openAPISpecHandler)
+	mux.HandleFunc("/api/docs", apiDocsHandler)
+
+	// Admin routes
+	mux.HandleFunc("/api/admin/regenerate-thumbnails", requireAdmin(regenerateThumbnailsHandler))
+	mux.HandleFunc("/api/admin/jobs/", requireAdmin(jobStatusHandler)) // GET /api/admin/jobs/{id}
+	mux.HandleFunc("/api/admin/audit", requireAdmin(auditLogHandler))
+	mux.HandleFunc("/api/admin/reconcile", requireAdmin(reconcileHandler))
+	mux.HandleFunc("/api/admin/backfill-phash", requireAdmin(backfillPerceptualHashesHandler))
+	mux.HandleFunc("/api/admin/db-stats", requireAdmin(dbStatsHandler))
+	mux.HandleFunc("/api/admin/usage-history", requireAdmin(usageHistoryHandler))
+	mux.HandleFunc("/api/admin/db-maintenance", requireAdmin(dbMaintenanceHandler))
+	mux.HandleFunc("/api/admin/import", requireAdmin(withBodyLimit(jsonLimit, importHandler)))
+	mux.HandleFunc("/api/admin/images/reassign", requireAdmin(withBodyLimit(jsonLimit, reassignImagesHandler)))
+	mux.HandleFunc(maintenancePath, requireAdmin(withBodyLimit(jsonLimit, maintenanceHandler)))
 
 	// Image related routes
 	mux.HandleFunc("/api/images/upload", uploadImageHandler)
-	mux.HandleFunc("/api/images", listImagesHandler) // GET for list
-	mux.HandleFunc("/api/images/file/", serveImageHandler) // GET /api/images/file/{disk_filename}
-	mux.HandleFunc("/api/images/delete/", deleteImageHandler) // DELETE /api/images/delete/{id}
+	mux.HandleFunc("/api/images", listImagesHandler)          // GET for list
+	mux.HandleFunc("/api/images/export", exportImagesHandler) // GET ZIP archive of all images
+	mux.HandleFunc("/api/images/bulk-tag", withBodyLimit(jsonLimit, bulkTagHandler))
+	mux.HandleFunc("/api/images/validate", withBodyLimit(jsonLimit, validateImagesHandler))
+	mux.HandleFunc("/api/images/facets", facetsHandler)
+	mux.HandleFunc("/api/images/count", countImagesHandler)
+	mux.HandleFunc("/api/images/changes", imageChangesHandler)                                  // GET ?since_id=&since_seq=
+	mux.HandleFunc("/api/images/file/", imageFileHandler)                                       // GET, DELETE /api/images/file/{disk_filename}
+	mux.HandleFunc("/api/images/delete/", deleteImageHandler)                                   // DELETE /api/images/delete/{id}
+	mux.HandleFunc("/api/images/", imageRoutes(withBodyLimit(jsonLimit, imageResourceHandler))) // /api/images/{id}[/...]
+
+	// Album routes
+	mux.HandleFunc("/api/albums", withBodyLimit(jsonLimit, albumsHandler))
+	mux.HandleFunc("/api/albums/", withBodyLimit(jsonLimit, albumResourceHandler)) // /api/albums/{id}[/images]
 
 	// ML related routes
-	mux.HandleFunc("/api/ml/start-training", startTrainingHandler)
+	mux.HandleFunc("/api/ml/start-training", withBodyLimit(jsonLimit, startTrainingHandler))
+	mux.HandleFunc("/api/ml/jobs/", trainingJobHandler) // GET, DELETE /api/ml/jobs/{id}; GET /api/ml/jobs/{id}/wait, /artifacts
+	mux.HandleFunc("/api/ml/models", modelsHandler)
+
+	server := &http.Server{
+		Handler:           loggingMiddleware(corsMiddleware(recoverMiddleware(authMiddleware(maintenanceMiddleware(compressMiddleware(mux)))))),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	// SIGINT/SIGTERM stop the purge worker and let in-flight requests finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	backgroundJobs.ctx = ctx
+	purgeDone := runPurgeWorker(ctx, cfg.PurgeInterval)
+	prewarmDone := runThumbnailPrewarmer(ctx)
+	downloadsDone := runDownloadFlusher(ctx)
+	simulatorDone := runTrainingSimulator(ctx)
 
-	log.Println("Starting Go backend server on port 8080...")
-	if err := http.ListenAndServe(":8080", mux); err != nil {
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Printf("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Warning: shutdown did not complete cleanly: %v", err)
+		}
+	}()
+
+	if err := serve(server); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Could not start server: %s\n", err.Error())
 	}
+	<-shutdownDone
+	<-purgeDone
+	<-prewarmDone
+	<-downloadsDone
+	<-simulatorDone
+	if err := downloads.Flush(context.Background()); err != nil {
+		log.Printf("Warning: final download count flush failed: %v", err)
+	}
+	log.Printf("Server stopped")
+}
+
+// HealthResponse reports the status of each dependency checked by /health.
+type HealthResponse struct {
+	DB          string `json:"db"`
+	Storage     string `json:"storage"`
+	Maintenance bool   `json:"maintenance"` // Read-only mode; does not make the check fail
 }
 
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	err := db.Ping()
+	health := HealthResponse{DB: "ok", Storage: "ok", Maintenance: maintenanceMode.Load()}
+	status := http.StatusOK
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		health.DB = "error"
+		status = http.StatusServiceUnavailable
+		log.Printf("Health check failed: database: %v", err)
+	}
+	// A read-only or full volume fails uploads while the database stays healthy.
+	for _, root := range uploadRoots() {
+		if err := checkStorageWritable(root); err != nil {
+			health.Storage = "error"
+			status = http.StatusServiceUnavailable
+			log.Printf("Health check failed: storage %s: %v", root, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+// checkStorageWritable writes and removes a small probe file in root.
+func checkStorageWritable(root string) error {
+	f, err := os.CreateTemp(root, ".healthcheck-*")
 	if err != nil {
-		http.Error(w, "Database connection error", http.StatusInternalServerError)
-		log.Printf("Health check failed: %v", err)
-		return
+		return err
 	}
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "OK")
+	defer os.Remove(f.Name())
+	if _, err := f.Write([]byte("ok")); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 func uploadImageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
 		return
 	}
 
-	// Max 10 MB files.
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		http.Error(w, "Could not parse multipart form: "+err.Error(), http.StatusBadRequest)
+	received, ok := receiveUpload(w, r)
+	if !ok {
 		return
 	}
+	defer releaseUploadSlot()
 
-	file, handler, err := r.FormFile("imageFile") // "imageFile" is the name of the form field
-	if err != nil {
-		http.Error(w, "Error retrieving the file: "+err.Error(), http.StatusBadRequest)
-		return
+	var owner sql.NullString
+	if p := principalFromContext(r.Context()); p != nil {
+		owner = sql.NullString{String: p.ID, Valid: true}
 	}
-	defer file.Close()
-
-	originalFilename := handler.Filename
-	contentType := handler.Header.Get("Content-Type")
-	fileSize := handler.Size
-
-	fileExtension := filepath.Ext(originalFilename)
-	diskFilename := uuid.New().String() + fileExtension
-	filePathOnDisk := filepath.Join(uploadPath, diskFilename)
 
-	dst, err := os.Create(filePathOnDisk)
+	lookupCtx, cancelLookup := dbContext(r.Context())
+	existingID, err := findImageIDByHash(lookupCtx, owner, received.ContentHash)
+	cancelLookup()
 	if err != nil {
-		http.Error(w, "Error creating the file on server: "+err.Error(), http.StatusInternalServerError)
+		os.Remove(received.path())
+		writeError(w, dbErrorStatus(err), "Error checking for duplicate image: "+err.Error())
+		return
+	}
+	if existingID != 0 {
+		os.Remove(received.path()) // The existing file already holds these bytes
+		writeDuplicateResponse(w, existingID)
 		return
 	}
-	defer dst.Close()
 
-	if _, err := io.Copy(dst, file); err != nil {
-		http.Error(w, "Error saving the file: "+err.Error(), http.StatusInternalServerError)
+	upload, ok := moderateAndProcessUpload(w, r, received)
+	if !ok {
 		return
 	}
+	root, diskFilename, filePathOnDisk := upload.Root, upload.DiskFilename, upload.path()
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
 
+	// createStoredFile chose a fresh name before the file was written, so a
+	// disk_filename clash here is not retried; it fails the upload like any
+	// other database error.
 	var imageID int
-	err = db.QueryRow(
-		"INSERT INTO images (original_filename, disk_filename, content_type, size) VALUES ($1, $2, $3, $4) RETURNING id",
-		originalFilename, diskFilename, contentType, fileSize,
+	err = dbQueryRow(ctx,
+		"INSERT INTO images (original_filename, disk_filename, content_type, size, content_sha256, width, height, thumb_filename, phash, storage_root, exif_stripped, owner) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id",
+		received.OriginalFilename, diskFilename, upload.ContentType, upload.Size, received.ContentHash, upload.Width, upload.Height, upload.ThumbFilename, upload.PHash, root, upload.ExifStripped, owner,
 	).Scan(&imageID)
-
 	if err != nil {
 		os.Remove(filePathOnDisk) // Attempt to clean up orphaned file
-		http.Error(w, "Error saving image metadata to database: "+err.Error(), http.StatusInternalServerError)
+		removeThumbnail(upload.ThumbFilename)
+		// A concurrent upload of the same bytes may have won the race to insert.
+		if isUniqueViolation(err, "images_owner_content_sha256_key") {
+			if existingID, lookupErr := findImageIDByHash(ctx, owner, received.ContentHash); lookupErr == nil && existingID != 0 {
+				writeDuplicateResponse(w, existingID)
+				return
+			}
+		}
+		writeError(w, dbErrorStatus(err), "Error saving image metadata to database: "+err.Error())
 		return
 	}
 
+	publishImageEvent(eventImageUploaded, imageID, diskFilename)
+
+	fileURL := externalURL(r, "/api/images/file/"+diskFilename)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", externalURL(r, fmt.Sprintf("/api/images/%d", imageID)))
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(SimpleResponse{Message: "Image uploaded successfully", ID: imageID})
+	json.NewEncoder(w).Encode(SimpleResponse{Message: "Image uploaded successfully", ID: imageID, FileURL: fileURL, ChecksumSHA256: received.ContentHash})
+}
+
+// receivedUpload is an uploaded file written to storage, hashed and checked
+// for integrity, but not yet deduplicated, moderated or processed.
+type receivedUpload struct {
+	processedUpload
+	OriginalFilename string
+	ContentHash      string // Hex SHA-256 of the bytes as uploaded
+}
+
+// receiveUpload reads the multipart file of an upload request into storage.
+// It holds one of the MAX_CONCURRENT_UPLOADS slots while doing so. When ok is
+// false it has written the error response and released everything; otherwise
+// the caller owns the stored file and must call releaseUploadSlot.
+func receiveUpload(w http.ResponseWriter, r *http.Request) (received receivedUpload, ok bool) {
+	// Uploads get a longer deadline than the server-wide read/write timeouts.
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(cfg.UploadTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		log.Printf("Warning: could not extend upload read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		log.Printf("Warning: could not extend upload write deadline: %v", err)
+	}
+
+	// Reject oversized uploads before reading any of the body. Go's server only
+	// answers "Expect: 100-continue" with "100 Continue" once the handler first
+	// reads r.Body, so a client waiting on the interim response receives the 413
+	// instead and aborts without streaming the payload. Requests without a
+	// declared length (chunked) are still capped by MaxBytesReader below.
+	if r.ContentLength > maxUploadSize {
+		writeUploadTooLarge(w)
+		return received, false
+	}
+	checksums, problems := parseClaimedChecksums(r)
+	if len(problems) > 0 {
+		writeValidationError(w, http.StatusBadRequest, problems)
+		return received, false
+	}
+
+	// The body is only read once a slot is free, so a burst of uploads queues
+	// here instead of buffering in memory and on disk all at once.
+	if !acquireUploadSlot(w, r) {
+		return received, false
+	}
+	defer func() {
+		if !ok {
+			releaseUploadSlot()
+		}
+	}()
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	// Parts that do not fit in memory spill to TEMP_DIR. Spill files are
+	// removed as soon as the upload has been copied to storage, and on every
+	// other return path by the deferred call.
+	err := r.ParseMultipartForm(multipartMaxMemory)
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeUploadTooLarge(w)
+			return received, false
+		}
+		if clientGone(r, err) {
+			abortUpload(w, "while sending the body", err)
+			return received, false
+		}
+		writeError(w, http.StatusBadRequest, "Could not parse multipart form: "+err.Error())
+		return received, false
+	}
+
+	handler, err := uploadedFilePart(r.MultipartForm)
+	if err != nil {
+		writeValidationError(w, http.StatusUnprocessableEntity, []FieldError{{Field: cfg.UploadFieldName, Reason: err.Error()}})
+		return received, false
+	}
+
+	// Report every problem the part's headers reveal in one response.
+	originalFilename := handler.Filename
+	contentType, _, err := mime.ParseMediaType(handler.Header.Get("Content-Type"))
+	if err != nil || !slices.Contains(cfg.AllowedContentTypes, contentType) {
+		problems = append(problems, FieldError{Field: cfg.UploadFieldName, Reason: fmt.Sprintf("unsupported type %q; allowed types are: %s", handler.Header.Get("Content-Type"), strings.Join(cfg.AllowedContentTypes, ", "))})
+	}
+	if handler.Size == 0 {
+		problems = append(problems, FieldError{Field: cfg.UploadFieldName, Reason: "file is empty"})
+	}
+	if utf8.RuneCountInString(originalFilename) > maxOriginalFilenameLength {
+		problems = append(problems, FieldError{Field: cfg.UploadFieldName, Reason: fmt.Sprintf("filename exceeds %d characters", maxOriginalFilenameLength)})
+	}
+	if len(problems) > 0 {
+		writeValidationError(w, http.StatusUnprocessableEntity, problems)
+		return received, false
+	}
+
+	file, err := handler.Open()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Error retrieving the file: "+err.Error())
+		return received, false
+	}
+	defer file.Close()
+
+	root, diskFilename, dst, err := createStoredFile(filepath.Ext(originalFilename))
+	if err != nil {
+		writeStorageError(w, "Error creating the file on server", err)
+		return received, false
+	}
+	defer dst.Close()
+	filePathOnDisk := storagePath(root, diskFilename)
+
+	// Hash while streaming to disk so duplicates are detected, and claimed
+	// checksums verified, without a second read.
+	hasher := sha256.New()
+	sinks := []io.Writer{dst, hasher}
+	for _, c := range checksums {
+		if c.hash != nil {
+			sinks = append(sinks, c.hash)
+		}
+	}
+	written, err := io.Copy(io.MultiWriter(sinks...), contextReader{r.Context(), file})
+	if err == nil {
+		// Delayed allocation can defer ENOSPC until the data is flushed.
+		err = dst.Sync()
+	}
+	if err != nil {
+		os.Remove(filePathOnDisk)
+		if clientGone(r, err) {
+			abortUpload(w, "while the file was stored", err)
+			return received, false
+		}
+		writeStorageError(w, "Error saving the file", err)
+		return received, false
+	}
+	file.Close()
+	r.MultipartForm.RemoveAll()
+	if msg := validateUploadedFile(filePathOnDisk, contentType, written, handler.Size); msg != "" {
+		os.Remove(filePathOnDisk)
+		writeValidationError(w, http.StatusUnprocessableEntity, []FieldError{{Field: cfg.UploadFieldName, Reason: msg}})
+		return received, false
+	}
+	if msg := resolutionError(filePathOnDisk); msg != "" {
+		os.Remove(filePathOnDisk)
+		writeValidationError(w, http.StatusRequestEntityTooLarge, []FieldError{{Field: cfg.UploadFieldName, Reason: msg}})
+		return received, false
+	}
+	sum := hasher.Sum(nil)
+	if problems := verifyChecksums(checksums, sum); len(problems) > 0 {
+		os.Remove(filePathOnDisk)
+		writeValidationError(w, http.StatusBadRequest, problems)
+		return received, false
+	}
+
+	received = receivedUpload{
+		processedUpload:  processedUpload{Root: root, DiskFilename: diskFilename, ContentType: contentType, Size: handler.Size},
+		OriginalFilename: originalFilename,
+		ContentHash:      hex.EncodeToString(sum),
+	}
+	return received, true
+}
+
+// moderateAndProcessUpload runs a received upload past the moderator and then
+// through its processing pipeline. When ok is false the stored files have
+// been removed and the error response written.
+func moderateAndProcessUpload(w http.ResponseWriter, r *http.Request, received receivedUpload) (upload processedUpload, ok bool) {
+	upload = received.processedUpload
+
+	// Malware is caught before the file is passed on to anything else. As
+	// with moderation, an unreachable scanner rejects the upload.
+	signature, err := virusScanner.Scan(r.Context(), upload.path())
+	if err != nil {
+		os.Remove(upload.path())
+		if clientGone(r, err) {
+			abortUpload(w, "during the malware scan", err)
+			return upload, false
+		}
+		virusScans.Add("error", 1)
+		log.Printf("Malware scan of %s failed: %v", upload.DiskFilename, err)
+		writeError(w, http.StatusServiceUnavailable, "Malware scanning is unavailable, please retry later")
+		return upload, false
+	}
+	if signature != "" {
+		os.Remove(upload.path())
+		virusScans.Add("infected", 1)
+		log.Printf("Rejected upload %q: malware detected (%s)", received.OriginalFilename, signature)
+		writeError(w, http.StatusUnprocessableEntity, "File rejected: malware detected ("+signature+")")
+		return upload, false
+	}
+	if cfg.ClamAVAddr != "" {
+		virusScans.Add("clean", 1)
+	}
+
+	// A moderator that cannot be reached rejects the upload rather than
+	// letting unchecked images into the dataset.
+	reason, err := moderator.Moderate(r.Context(), upload.path(), upload.ContentType)
+	if err != nil {
+		os.Remove(upload.path())
+		if clientGone(r, err) {
+			abortUpload(w, "during moderation", err)
+			return upload, false
+		}
+		log.Printf("Moderation of %s failed: %v", upload.DiskFilename, err)
+		writeError(w, http.StatusServiceUnavailable, "Image moderation is unavailable, please retry later")
+		return upload, false
+	}
+	if reason != "" {
+		os.Remove(upload.path())
+		writeError(w, http.StatusUnprocessableEntity, "Image rejected by moderation: "+reason)
+		return upload, false
+	}
+
+	// Format-specific work (metadata stripping, conversion, thumbnails) is
+	// chosen by content type; see uploadProcessors. It is CPU-bound, so it
+	// waits its turn in the IMAGE_PROCESSING_WORKERS pool.
+	var processErr error
+	if err := imageProcessing.Run(r.Context(), func() { processErr = processUpload(&upload) }); err != nil {
+		os.Remove(upload.path())
+		abortUpload(w, "while waiting for image processing", err)
+		return upload, false
+	}
+	if processErr != nil {
+		os.Remove(upload.path())
+		removeThumbnail(upload.ThumbFilename)
+		writeStorageError(w, "Error processing the image", processErr)
+		return upload, false
+	}
+	// Processing cannot be interrupted, but a client that left meanwhile
+	// gets no row.
+	if err := r.Context().Err(); err != nil {
+		os.Remove(upload.path())
+		removeThumbnail(upload.ThumbFilename)
+		abortUpload(w, "during image processing", err)
+		return upload, false
+	}
+	return upload, true
+}
+
+// writeUploadTooLarge answers an upload over maxUploadSize. The body is never
+// read, so no other problems can be reported alongside.
+func writeUploadTooLarge(w http.ResponseWriter) {
+	writeValidationError(w, http.StatusRequestEntityTooLarge, []FieldError{{
+		Field:  cfg.UploadFieldName,
+		Reason: fmt.Sprintf("upload exceeds maximum size of %d bytes", maxUploadSize),
+	}})
+}
+
+// uploadedFilePart returns the file part named cfg.UploadFieldName or, for
+// clients that use another field name, the first file part in the form. The
+// error lists the form's part names when it contains no file at all.
+func uploadedFilePart(form *multipart.Form) (*multipart.FileHeader, error) {
+	if files := form.File[cfg.UploadFieldName]; len(files) > 0 {
+		return files[0], nil
+	}
+	names := make([]string, 0, len(form.File)+len(form.Value))
+	for name := range form.File {
+		names = append(names, name)
+	}
+	if len(names) > 0 {
+		sort.Strings(names) // Deterministic choice among several file parts
+		return form.File[names[0]][0], nil
+	}
+	for name := range form.Value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("no file part in form (expected field %q); parts received: [%s]", cfg.UploadFieldName, strings.Join(names, ", "))
+}
+
+// validateUploadedFile checks a freshly written upload for signs of a broken
+// client: no bytes, fewer bytes than the multipart part declared, or an image
+// whose header cannot be decoded. It returns a reason for the client, or ""
+// if the file looks intact.
+func validateUploadedFile(path, contentType string, written, declared int64) string {
+	if written == 0 {
+		return "file is empty"
+	}
+	if written != declared {
+		return fmt.Sprintf("file is truncated: received %d of %d bytes", written, declared)
+	}
+	// Only formats the server can decode are checked; other types are stored as-is.
+	if _, ok := imageEncoders[contentType]; ok {
+		if width, _ := imageDimensions(path); width == nil {
+			return "file is not a valid " + contentType + " image"
+		}
+	}
+	if slices.Contains(heifContentTypes, contentType) {
+		if width, _ := heifDimensions(path); width == nil {
+			return "file is not a valid " + contentType + " image"
+		}
+	}
+	return ""
+}
+
+// findImageIDByHash returns the ID of owner's image with the given content
+// hash, or 0 if owner has no such image. A NULL owner stands for the
+// anonymous uploads.
+func findImageIDByHash(ctx context.Context, owner sql.NullString, contentHash string) (int, error) {
+	var id int
+	err := dbQueryRow(ctx, "SELECT id FROM images WHERE COALESCE(owner, '') = $1 AND content_sha256 = $2", owner.String, contentHash).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// writeDuplicateResponse answers an upload whose content matches an existing
+// image, according to the configured dedup mode.
+func writeDuplicateResponse(w http.ResponseWriter, existingID int) {
+	w.Header().Set("Content-Type", "application/json")
+	if cfg.DedupMode == dedupModeReturn {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SimpleResponse{Message: "Image already exists", ID: existingID})
+		return
+	}
+	retryable := false
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(SimpleResponse{Error: "An identical image has already been uploaded", Code: errorCode(http.StatusConflict), ID: existingID, Retryable: &retryable})
+}
+
+// isUniqueViolation reports whether err is a Postgres unique_violation on the
+// named constraint or index.
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+// imageListFilters builds the WHERE conditions, numbered from $1, for the
+// filter parameters shared by GET /api/images and GET /api/images/count. It
+// writes a 400 response and returns false if any is invalid.
+func imageListFilters(w http.ResponseWriter, r *http.Request) (conditions []string, args []any, ok bool) {
+	dimConditions, dimArgs, err := dimensionFilters(r.URL.Query(), len(args))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+	conditions = append(conditions, dimConditions...)
+	args = append(args, dimArgs...)
+
+	dateConditions, dateArgs, err := uploadDateFilters(r.URL.Query(), len(args))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+	conditions = append(conditions, dateConditions...)
+	args = append(args, dateArgs...)
+
+	// Each ?tag= narrows the result to images carrying that tag (AND semantics).
+	if tagParams := r.URL.Query()["tag"]; len(tagParams) > 0 {
+		tags, err := normalizeTags(tagParams)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid tag parameter: "+err.Error())
+			return nil, nil, false
+		}
+		args = append(args, pq.Array(tags), len(tags))
+		conditions = append(conditions, fmt.Sprintf(`id IN (
+			SELECT it.image_id FROM image_tags it JOIN tags t ON t.id = it.tag_id
+			WHERE t.name = ANY($%d) GROUP BY it.image_id HAVING COUNT(*) = $%d)`, len(args)-1, len(args)))
+	}
+
+	if albumParam := r.URL.Query().Get("album_id"); albumParam != "" {
+		albumID, err := strconv.Atoi(albumParam)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid album_id parameter: "+albumParam)
+			return nil, nil, false
+		}
+		args = append(args, albumID)
+		conditions = append(conditions, fmt.Sprintf("album_id = $%d", len(args)))
+	}
+
+	favCondition, favArg, ok := favoritesOnlyFilter(w, r, len(args))
+	if !ok {
+		return nil, nil, false
+	}
+	if favCondition != "" {
+		args = append(args, favArg)
+		conditions = append(conditions, favCondition)
+	}
+
+	if contentType := r.URL.Query().Get("content_type"); contentType != "" {
+		args = append(args, contentType)
+		conditions = append(conditions, fmt.Sprintf("content_type = $%d", len(args)))
+	}
+	if owner := r.URL.Query().Get("owner"); owner != "" {
+		args = append(args, owner)
+		conditions = append(conditions, fmt.Sprintf("owner = $%d", len(args)))
+	}
+	return conditions, args, true
 }
 
 func listImagesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
 		return
 	}
 
-	rows, err := db.Query("SELECT id, original_filename, disk_filename, content_type, size, uploaded_at FROM images ORDER BY uploaded_at DESC")
+	// Sort column and direction are validated against allowlists since they
+	// cannot be passed as query parameters and end up in the SQL text.
+	sortColumn := "uploaded_at"
+	if sortParam := r.URL.Query().Get("sort"); sortParam != "" {
+		col, ok := sortableColumns[sortParam]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid sort parameter: "+sortParam)
+			return
+		}
+		sortColumn = col
+	}
+	sortOrder := "DESC"
+	if orderParam := r.URL.Query().Get("order"); orderParam != "" {
+		order, ok := sortOrders[strings.ToLower(orderParam)]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid order parameter: "+orderParam)
+			return
+		}
+		sortOrder = order
+	}
+
+	limit, offset, clamped, ok := parseLimitOffset(w, r, cfg.DefaultPageSize, cfg.MaxPageSize)
+	if !ok {
+		return
+	}
+
+	conditions, args, ok := imageListFilters(w, r)
+	if !ok {
+		return
+	}
+
+	// ?after= continues from a cursor returned as next_cursor. It replaces
+	// ?offset= and stays stable while new images are uploaded. It is added
+	// last so the filters before it can be reused for the total count.
+	filterCount, filterArgCount := len(conditions), len(args)
+	afterParam := r.URL.Query().Get("after")
+	if afterParam != "" {
+		if r.URL.Query().Has("offset") {
+			writeError(w, http.StatusBadRequest, "after and offset cannot be combined")
+			return
+		}
+		if sortColumn != "uploaded_at" {
+			writeError(w, http.StatusBadRequest, "after requires sorting by uploaded_at")
+			return
+		}
+		cursor, err := decodeImageCursor(afterParam)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid after parameter")
+			return
+		}
+		cmp := "<"
+		if sortOrder == "ASC" {
+			cmp = ">"
+		}
+		args = append(args, cursor.UploadedAt.Format(cursorTimeLayout), cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(uploaded_at, id) %s ($%d::timestamp, $%d)", cmp, len(args)-1, len(args)))
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+
+	// Read the version before the rows: a change in between makes the next
+	// poll refetch rather than hiding the change.
+	changedAt, err := imageListChangedAt(ctx)
 	if err != nil {
-		http.Error(w, "Error querying database: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+	setListVersionHeaders(w, changedAt)
+	if listNotModified(r, changedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var total int
+	countQuery := "SELECT COUNT(*) FROM images"
+	if filterCount > 0 {
+		countQuery += " WHERE " + strings.Join(conditions[:filterCount], " AND ")
+	}
+	if err := dbQueryRow(ctx, countQuery, args[:filterArgCount]...).Scan(&total); err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+
+	query := "SELECT " + imageColumns + " FROM images"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s", sortColumn, sortOrder, sortOrder)
+	// One extra row tells whether another page follows.
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" LIMIT $%d", len(args))
+	if afterParam == "" {
+		args = append(args, offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	rows, err := dbQuery(ctx, query, args...)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
 		return
 	}
 	defer rows.Close()
 
-	var images []ImageMetadata
+	page := ImagePage{APIVersion: imagePageVersion, Images: []ImageMetadata{}, Limit: limit, Clamped: clamped}
+	if afterParam == "" {
+		page.Offset = &offset
+	}
+	hasMore := false
 	for rows.Next() {
-		var img ImageMetadata
-		if err := rows.Scan(&img.ID, &img.OriginalFilename, &img.DiskFilename, &img.ContentType, &img.Size, &img.UploadedAt); err != nil {
-			http.Error(w, "Error scanning database results: "+err.Error(), http.StatusInternalServerError)
+		img, err := scanImage(rows)
+		if err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
 			return
 		}
-		images = append(images, img)
+		if len(page.Images) == limit {
+			hasMore = true
+			break
+		}
+		page.Images = append(page.Images, img)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+	if err := markFavorites(ctx, r, page.Images); err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying favorites: "+err.Error())
+		return
 	}
+	if hasMore && sortColumn == "uploaded_at" {
+		page.NextCursor = encodeImageCursor(page.Images[len(page.Images)-1])
+	}
+	setPaginationHeaders(w, r, total, page)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(images)
+	json.NewEncoder(w).Encode(page)
 }
 
-func serveImageHandler(w http.ResponseWriter, r *http.Request) {
+// imageRoutes routes PUT /api/images/{id}/file, an upload whose size
+// receiveUpload limits, straight to imageResourceHandler, and every other
+// /api/images/{id} request through limited, which caps JSON bodies.
+func imageRoutes(limited http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/file") {
+			imageResourceHandler(w, r)
+			return
+		}
+		limited(w, r)
+	}
+}
+
+// imageResourceHandler routes requests under /api/images/{id}.
+func imageResourceHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/images/")
+	idStr, subPath, _ := strings.Cut(rest, "/")
+	imageID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid Image ID format")
+		return
+	}
+
+	switch {
+	case subPath == "" && r.Method == http.MethodPatch:
+		updateImageHandler(w, r, imageID)
+	case subPath == "":
+		getImageHandler(w, r, imageID)
+	case subPath == "tags":
+		addImageTagsHandler(w, r, imageID)
+	case strings.HasPrefix(subPath, "tags/"):
+		removeImageTagHandler(w, r, imageID, strings.TrimPrefix(subPath, "tags/"))
+	case subPath == "rotate":
+		rotateImageHandler(w, r, imageID)
+	case subPath == "full":
+		imageDetailHandler(w, r, imageID)
+	case subPath == "signed-url":
+		signedURLHandler(w, r, imageID)
+	case subPath == "thumbnail":
+		thumbnailHandler(w, r, imageID)
+	case subPath == "datauri":
+		dataURIHandler(w, r, imageID)
+	case subPath == "raw":
+		rawImageHandler(w, r, imageID)
+	case subPath == "similar":
+		similarImagesHandler(w, r, imageID)
+	case subPath == "favorite":
+		favoriteImageHandler(w, r, imageID)
+	case subPath == "file":
+		replaceImageFileHandler(w, r, imageID)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func getImageHandler(w http.ResponseWriter, r *http.Request, imageID int) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Only GET and PATCH methods are allowed")
+		return
+	}
+	writeImageMetadata(w, r, imageID)
+}
+
+// updateImageHandler handles PATCH /api/images/{id}. Only the display name
+// can be changed; the file on disk keeps its generated name.
+func updateImageHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	var req UpdateImageRequest
+	if !decodeJSONBody(w, r, &req, "original_filename") {
+		return
+	}
+	name := strings.TrimSpace(req.OriginalFilename)
+	if name == "" || utf8.RuneCountInString(name) > maxOriginalFilenameLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("original_filename is required and must be at most %d characters", maxOriginalFilenameLength))
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	var owner sql.NullString
+	err := dbQueryRow(ctx, "SELECT owner FROM images WHERE id = $1", imageID).Scan(&owner)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	if !mayModifyImage(r, owner) {
+		writeError(w, http.StatusForbidden, "Only the uploader of this image or an admin may rename it")
+		return
+	}
+
+	result, err := dbExec(ctx, "UPDATE images SET original_filename = $1 WHERE id = $2", name, imageID)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error updating image metadata: "+err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "Image not found")
 		return
 	}
+	imageCache.InvalidateID(imageID)
+
+	writeImageMetadata(w, r, imageID)
+}
+
+// writeImageMetadata responds with the current metadata of an image, or 404
+// if it does not exist.
+func writeImageMetadata(w http.ResponseWriter, r *http.Request, imageID int) {
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	img, err := getImageMetadata(ctx, imageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	images := []ImageMetadata{img}
+	if err := markFavorites(ctx, r, images); err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying favorites: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(images[0])
+}
+
+// imageFileHandler routes /api/images/file/{disk_filename} by method.
+func imageFileHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		// http.ServeFile answers HEAD with the GET headers and no body.
+		serveImageHandler(w, r)
+	case http.MethodDelete:
+		deleteImageByFilenameHandler(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Only GET, HEAD and DELETE methods are allowed")
+	}
+}
+
+// diskFilenameFromPath extracts and sanitizes the {disk_filename} path
+// segment, writing a 400 response and returning false if it is unusable.
+func diskFilenameFromPath(w http.ResponseWriter, r *http.Request) (string, bool) {
 	diskFilename := strings.TrimPrefix(r.URL.Path, "/api/images/file/")
 	if diskFilename == "" {
-		http.Error(w, "Filename not provided", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Filename not provided")
+		return "", false
+	}
+
+	// Only flat names and YYYY/MM/DD/ partitioned paths are accepted, which
+	// rules out path traversal.
+	if !validDiskFilename(diskFilename) || strings.Contains(diskFilename, "..") {
+		writeError(w, http.StatusBadRequest, "Invalid filename")
+		return "", false
+	}
+	return diskFilename, true
+}
+
+func serveImageHandler(w http.ResponseWriter, r *http.Request) {
+	cleanFilename, ok := diskFilenameFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !mayReadImageFile(w, r, cleanFilename) {
+		return
+	}
+
+	// Only serve files that belong to a known image.
+	ctx, cancel := dbContext(r.Context())
+	img, err := lookupImageByFilename(ctx, cleanFilename)
+	cancel()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
 		return
 	}
+	serveStoredImage(w, r, img)
+}
 
-	// Basic sanitization to prevent path traversal
-	// A more robust solution would involve checking against a list of known valid filenames from DB
-	// or ensuring no ".." components are present.
-	cleanFilename := filepath.Base(diskFilename)
-	if cleanFilename != diskFilename || strings.Contains(diskFilename, "..") {
-		http.Error(w, "Invalid filename", http.StatusBadRequest)
+// rawImageHandler handles GET /api/images/{id}/raw, serving the same bytes
+// as /api/images/file/{disk_filename} for clients that only know the ID.
+// With URL signing enabled it requires the signature of the file URL.
+func rawImageHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET and HEAD methods are allowed")
 		return
 	}
 
-	filePath := filepath.Join(uploadPath, cleanFilename)
-	http.ServeFile(w, r, filePath)
+	ctx, cancel := dbContext(r.Context())
+	img, err := getImageMetadata(ctx, imageID)
+	cancel()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+
+	if !mayReadImageFile(w, r, img.DiskFilename) {
+		return
+	}
+	serveStoredImage(w, r, img)
+}
+
+// serveStoredImage writes the file of img, resized when the request asks for
+// it.
+func serveStoredImage(w http.ResponseWriter, r *http.Request, img ImageMetadata) {
+	width, height, resize, err := parseResizeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid resize parameters: "+err.Error())
+		return
+	}
+	if resize {
+		recordDownload(r, img.ID)
+		serveResizedImage(w, r, img, width, height)
+		return
+	}
+
+	if variant := negotiatedVariant(w, r, img); variant != "" {
+		w.Header().Set("Content-Type", "image/webp")
+		w.Header().Set("ETag", `"`+filepath.Base(variant)+`"`)
+		recordDownload(r, img.ID)
+		http.ServeFile(w, r, variant)
+		return
+	}
+	if img.ContentType != "" {
+		w.Header().Set("Content-Type", img.ContentType)
+	}
+	// Stored files are never rewritten in place (a rotation writes a new disk
+	// filename), so the name is a valid strong validator.
+	w.Header().Set("ETag", `"`+img.DiskFilename+`"`)
+	recordDownload(r, img.ID)
+	http.ServeFile(w, r, storagePath(img.StorageRoot, img.DiskFilename))
 }
 
 func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Only DELETE method is allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Only DELETE method is allowed")
 		return
 	}
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/images/delete/")
 	if idStr == "" {
-		http.Error(w, "Image ID not provided", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Image ID not provided")
 		return
 	}
 
 	imageID, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid Image ID format", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid Image ID format")
 		return
 	}
 
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
 	var diskFilename string
-	err = db.QueryRow("SELECT disk_filename FROM images WHERE id = $1", imageID).Scan(&diskFilename)
+	var owner sql.NullString
+	err = dbQueryRow(ctx, "SELECT disk_filename, owner FROM images WHERE id = $1", imageID).Scan(&diskFilename, &owner)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			http.Error(w, "Image not found", http.StatusNotFound)
+			writeError(w, http.StatusNotFound, "Image not found")
 		} else {
-			http.Error(w, "Error querying image from database: "+err.Error(), http.StatusInternalServerError)
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
 		}
 		return
 	}
 
-	// Delete from database
-	_, err = db.Exec("DELETE FROM images WHERE id = $1", imageID)
+	deleteImage(ctx, w, r, imageID, diskFilename, owner)
+}
+
+// deleteImageByFilenameHandler handles DELETE /api/images/file/{disk_filename}
+// for tooling that knows the stored filename but not the image ID.
+func deleteImageByFilenameHandler(w http.ResponseWriter, r *http.Request) {
+	diskFilename, ok := diskFilenameFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	var imageID int
+	var owner sql.NullString
+	err := dbQueryRow(ctx, "SELECT id, owner FROM images WHERE disk_filename = $1", diskFilename).Scan(&imageID, &owner)
 	if err != nil {
-		http.Error(w, "Error deleting image metadata from database: "+err.Error(), http.StatusInternalServerError)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
 		return
 	}
 
+	deleteImage(ctx, w, r, imageID, diskFilename, owner)
+}
+
+// DeleteResponse is returned by the delete endpoints. With ?dry_run=true it
+// describes what would be deleted and nothing is changed.
+type DeleteResponse struct {
+	Message        string         `json:"message"`
+	DryRun         bool           `json:"dry_run,omitempty"`
+	Deleted        []DeletedImage `json:"deleted"`
+	BytesReclaimed int64          `json:"bytes_reclaimed"` // Original, thumbnail and resized variants
+}
+
+// DeletedImage is one image in a DeleteResponse.
+type DeletedImage struct {
+	ID           int    `json:"id"`
+	DiskFilename string `json:"disk_filename"`
+	Bytes        int64  `json:"bytes"`
+}
+
+// deleteImage removes an image's database row and file, then writes the
+// success response. With ?dry_run=true it only reports what it would remove.
+// Either way the caller must be allowed to modify the image, whose owner is
+// given.
+func deleteImage(ctx context.Context, w http.ResponseWriter, r *http.Request, imageID int, diskFilename string, owner sql.NullString) {
+	if !mayModifyImage(r, owner) {
+		writeError(w, http.StatusForbidden, "Only the uploader of this image or an admin may delete it")
+		return
+	}
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+	if dryRun {
+		var thumbFilename sql.NullString
+		var root string
+		err := dbQueryRow(ctx, "SELECT thumb_filename, COALESCE(storage_root, '') FROM images WHERE id = $1", imageID).Scan(&thumbFilename, &root)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				writeError(w, http.StatusNotFound, "Image not found")
+			} else {
+				writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+			}
+			return
+		}
+		writeDeleteResponse(w, "Dry run: image would be deleted", true, imageID, diskFilename, imageFileBytes(root, diskFilename, thumbFilename))
+		return
+	}
+
+	// Delete from database, recording the deletion in the same transaction
+	tx, err := dbBegin(ctx)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error starting transaction: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	var thumbFilename sql.NullString
+	var root string
+	err = tx.QueryRowContext(ctx, "DELETE FROM images WHERE id = $1 RETURNING thumb_filename, COALESCE(storage_root, '')", imageID).Scan(&thumbFilename, &root)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error deleting image metadata from database: "+err.Error())
+		}
+		return
+	}
+	if err := recordAudit(ctx, tx, r, auditActionDelete, []int{imageID}); err != nil {
+		writeError(w, dbErrorStatus(err), "Error recording audit entry: "+err.Error())
+		return
+	}
+
+	filePathOnDisk := storagePath(root, diskFilename)
+	if cfg.StrictDelete {
+		// Move the file aside before committing so that a file that cannot be
+		// removed keeps its row, and a failed commit can put the file back.
+		pendingPath := filePathOnDisk + ".deleting"
+		if err := os.Rename(filePathOnDisk, pendingPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			writeError(w, http.StatusInternalServerError, "Error deleting image file: "+err.Error())
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			os.Rename(pendingPath, filePathOnDisk)
+			writeError(w, dbErrorStatus(err), "Error committing deletion: "+err.Error())
+			return
+		}
+		filePathOnDisk = pendingPath
+	} else if err := tx.Commit(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error committing deletion: "+err.Error())
+		return
+	}
+	imageCache.InvalidateID(imageID)
+	bytes := imageFileBytes(root, diskFilename, thumbFilename)
+	if cfg.StrictDelete {
+		bytes += sizeOnDisk(filePathOnDisk) // The original was moved aside
+	}
+
 	// Delete from filesystem
-	filePathOnDisk := filepath.Join(uploadPath, diskFilename)
 	err = os.Remove(filePathOnDisk)
-	if err != nil {
+	if err != nil && !(cfg.StrictDelete && errors.Is(err, fs.ErrNotExist)) {
 		// Log this error, but don't fail the request if DB entry was removed.
 		// The file might have been already deleted or there are permission issues.
 		log.Printf("Warning: failed to delete image file %s: %v", filePathOnDisk, err)
 	}
+	removeThumbnail(thumbFilename)
+	removeDerivedImages(diskFilename)
+	publishImageEvent(eventImageDeleted, imageID, diskFilename)
 
+	writeDeleteResponse(w, "Image deleted successfully", false, imageID, diskFilename, bytes)
+}
+
+func writeDeleteResponse(w http.ResponseWriter, msg string, dryRun bool, imageID int, diskFilename string, bytes int64) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(SimpleResponse{Message: "Image deleted successfully"})
+	json.NewEncoder(w).Encode(DeleteResponse{
+		Message:        msg,
+		DryRun:         dryRun,
+		Deleted:        []DeletedImage{{ID: imageID, DiskFilename: diskFilename, Bytes: bytes}},
+		BytesReclaimed: bytes,
+	})
 }
 
-func startTrainingHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
-		return
+// imageFileBytes returns the bytes on disk taken by an image's original,
+// thumbnail and cached resized variants. Missing files count as zero.
+func imageFileBytes(root, diskFilename string, thumbFilename sql.NullString) int64 {
+	total := sizeOnDisk(storagePath(root, diskFilename))
+	if thumbFilename.Valid {
+		total += sizeOnDisk(thumbnailPath(thumbFilename.String))
 	}
+	for _, path := range derivedImagePaths(diskFilename) {
+		total += sizeOnDisk(path)
+	}
+	return total
+}
 
-	log.Println("Received request to start custom ML training.")
-	// In a real scenario:
-	// 1. Validate request (e.g., user authentication, parameters).
-	// 2. Fetch image data/paths from PostgreSQL (using disk_filename) or a shared volume (uploadPath).
-	//    The images are already in uploadPath. The ml-trainer service would need access to this volume.
-	// 3. Trigger the ML training script/process (e.g., via Docker exec, gRPC call to ML service, message queue).
-	// 4. Monitor training progress.
-
-	w.Header().Set("Content-Type", "application/json")
-	response := SimpleResponse{Message: "Solicitud de entrenamiento personalizado recibida. Proceso simulado iniciado."}
-	json.NewEncoder(w).Encode(response)
+// sizeOnDisk returns the size of the file at path, or 0 if it cannot be read.
+func sizeOnDisk(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+const maintenancePath = "/api/admin/maintenance"
+
+// maintenanceRetryAfter is the Retry-After hint, in seconds, sent with 503s
+// while maintenance mode is on.
+const maintenanceRetryAfter = "300"
+
+// maintenanceMode is set from MAINTENANCE_MODE at startup and can be flipped
+// at runtime through PUT /api/admin/maintenance. It is not persisted.
+var maintenanceMode atomic.Bool
+
+// MaintenanceState is the body of GET and PUT /api/admin/maintenance.
+type MaintenanceState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// maintenanceMiddleware answers every mutating request with 503 while
+// maintenance mode is on, so the gallery keeps serving during a migration
+// but nothing changes. The toggle itself stays reachable.
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceMode.Load() && !readOnlyMethod(r.Method) && r.URL.Path != maintenancePath {
+			w.Header().Set("Retry-After", maintenanceRetryAfter)
+			writeError(w, http.StatusServiceUnavailable, "The API is in read-only maintenance mode; changes are disabled")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func readOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// maintenanceHandler handles GET and PUT /api/admin/maintenance.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req MaintenanceState
+		if !decodeJSONBody(w, r, &req, "enabled") {
+			return
+		}
+		if maintenanceMode.Swap(req.Enabled) != req.Enabled {
+			log.Printf("Maintenance mode set to %t by %s", req.Enabled, clientIP(r, cfg.TrustedProxies))
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Only GET and PUT methods are allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MaintenanceState{Enabled: maintenanceMode.Load()})
+}
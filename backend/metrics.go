@@ -0,0 +1,34 @@
+package main
+
+import "expvar"
+
+// Counters published at /metrics in expvar's JSON format.
+var (
+	metadataCacheHits   = expvar.NewInt("metadata_cache_hits")
+	metadataCacheMisses = expvar.NewInt("metadata_cache_misses")
+
+	eventsDelivered = expvar.NewInt("events_delivered")
+	eventsFailed    = expvar.NewInt("events_failed")  // Gave up after retries
+	eventsDropped   = expvar.NewInt("events_dropped") // Queue was full
+
+	slowRequests = expvar.NewInt("slow_requests") // Exceeded SLOW_REQUEST_THRESHOLD (SLOW_UPLOAD_THRESHOLD for uploads)
+	panicsTotal  = expvar.NewInt("panics_total")  // Handler panics recovered by recoverMiddleware
+
+	diskFullErrors = expvar.NewInt("disk_full_errors") // Uploads refused with 507
+
+	uploadsInFlight       = expvar.NewInt("uploads_in_flight")       // Uploads holding one of the MAX_CONCURRENT_UPLOADS slots
+	uploadsRejectedBusy   = expvar.NewInt("uploads_rejected_busy")   // Refused with 503 for lack of a slot
+	uploadsAborted        = expvar.NewInt("uploads_aborted")         // Abandoned by a disconnected client
+	imageProcessingQueued = expvar.NewInt("image_processing_queued") // Uploads waiting for an IMAGE_PROCESSING_WORKERS worker
+
+	thumbnailsPending       = expvar.NewInt("thumbnails_pending")        // Left in the current prewarm pass
+	thumbnailsPrewarmed     = expvar.NewInt("thumbnails_prewarmed")      // Generated by the prewarm worker
+	thumbnailsPrewarmFailed = expvar.NewInt("thumbnails_prewarm_failed") // Not retried until restart
+
+	purgedItems   = expvar.NewMap("purged_items")   // Removed by the purge worker, per category
+	purgeFailures = expvar.NewInt("purge_failures") // Purge task runs that hit an error
+
+	virusScans = expvar.NewMap("virus_scans") // Upload scan results: clean, infected or error
+
+	downloadFlushFailures = expvar.NewInt("download_flush_failures") // Counts kept in memory for the next flush
+)
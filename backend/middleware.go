@@ -0,0 +1,249 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// compressibleTypes lists the response media types worth compressing. Image
+// and archive responses are already compressed and pass through untouched.
+var compressibleTypes = map[string]bool{
+	"application/json": true,
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(io.Discard) },
+}
+
+// compressMiddleware gzip- or deflate-encodes compressible responses when the
+// client advertises support for it in Accept-Encoding.
+func compressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" if neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressResponseWriter decides on the first WriteHeader/Write whether to
+// compress, based on the response's Content-Type and existing encoding.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	writer   io.WriteCloser // Non-nil once compression is in effect
+	decided  bool
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if !cw.decided {
+		cw.decided = true
+		cw.startCompression(status)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *compressResponseWriter) startCompression(status int) {
+	h := cw.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if !compressibleTypes[mediaType] {
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if status == http.StatusNoContent || status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return
+	}
+
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length") // The length of the encoded body is unknown up front
+	if cw.encoding == "gzip" {
+		gz := gzipWriterPool.Get().(*gzip.Writer)
+		gz.Reset(cw.ResponseWriter)
+		cw.writer = gz
+	} else {
+		fw, _ := flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+		cw.writer = fw
+	}
+}
+
+// Flush writes any buffered compressed data through to the client.
+func (cw *compressResponseWriter) Flush() {
+	if f, ok := cw.writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the compressed stream, writing any trailer.
+func (cw *compressResponseWriter) Close() error {
+	if cw.writer == nil {
+		return nil
+	}
+	err := cw.writer.Close()
+	if gz, ok := cw.writer.(*gzip.Writer); ok {
+		gzipWriterPool.Put(gz)
+	}
+	cw.writer = nil
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection, e.g.
+// to extend deadlines for uploads.
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// loggingMiddleware writes an access log line for every request, attributing
+// it to the real client address behind any trusted proxies. Each request gets
+// an ID, taken from X-Request-ID when the caller supplies a usable one, which
+// is echoed in the response. Requests slower than the configured threshold
+// are additionally logged as warnings and counted.
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		duration := time.Since(start)
+		log.Printf("%s %s %s %d %v %s", clientIP(r, cfg.TrustedProxies), r.Method, r.URL.Path, sw.status, duration, requestID)
+
+		threshold := cfg.SlowRequestThreshold
+		if r.URL.Path == "/api/images/upload" {
+			threshold = cfg.SlowUploadThreshold // Large uploads are legitimately slow
+		}
+		if duration > threshold {
+			slowRequests.Add(1)
+			log.Printf("WARN slow request: %s %s took %v (threshold %v), status %d, request_id=%s", r.Method, r.URL.Path, duration, threshold, sw.status, requestID)
+		}
+	})
+}
+
+// recoverMiddleware turns a panicking handler into a 500 response and a
+// logged stack trace, so one bad request cannot take the process down. It
+// sits inside loggingMiddleware, which has already set X-Request-ID.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &startedResponseWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p) // A deliberate abort, not a bug
+			}
+			panicsTotal.Add(1)
+			log.Printf("PANIC serving %s %s, request_id=%s: %v\n%s", r.Method, r.URL.Path, w.Header().Get("X-Request-ID"), p, debug.Stack())
+			if tw.started {
+				// Part of the response is out; abort the connection so the
+				// client does not take it for a complete one.
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// startedResponseWriter records whether the response has been started.
+type startedResponseWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (tw *startedResponseWriter) WriteHeader(status int) {
+	tw.started = true
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *startedResponseWriter) Write(b []byte) (int, error) {
+	tw.started = true
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *startedResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// validRequestID accepts caller-supplied IDs that are short and printable,
+// so they cannot forge or garble log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// statusResponseWriter records the status code written by a handler.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusResponseWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"br", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"GZip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"br, deflate;q=0.5", "deflate"},
+		// gzip wins whenever it is acceptable, whatever the weights.
+		{"gzip;q=0.1, deflate;q=0.9", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{" gzip ; q=0.8 ", "gzip"},
+		// An unparsable weight counts as 1.
+		{"gzip;q=high", "gzip"},
+		// Wildcards are not expanded.
+		{"*", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
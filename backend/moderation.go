@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// Moderator decides whether an uploaded image may be stored.
+type Moderator interface {
+	// Moderate inspects the file at path and returns a non-empty reason if
+	// the image must be rejected.
+	Moderate(ctx context.Context, path, contentType string) (reason string, err error)
+}
+
+var moderator Moderator = noopModerator{} // Replaced in main when MODERATION_URL is set
+
+// noopModerator accepts every image.
+type noopModerator struct{}
+
+func (noopModerator) Moderate(context.Context, string, string) (string, error) { return "", nil }
+
+// httpModerator POSTs the raw image to an external service, which answers
+// with a ModerationResult.
+type httpModerator struct {
+	url    string
+	client *http.Client
+}
+
+// ModerationResult is the response expected from the moderation service.
+type ModerationResult struct {
+	Flagged bool   `json:"flagged"`
+	Reason  string `json:"reason"`
+}
+
+func (m httpModerator) Moderate(ctx context.Context, path, contentType string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, f)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("moderation service returned %s", resp.Status)
+	}
+
+	var result ModerationResult
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return "", fmt.Errorf("decoding moderation response: %w", err)
+	}
+	if !result.Flagged {
+		return "", nil
+	}
+	if result.Reason == "" {
+		return "flagged by moderation", nil
+	}
+	return result.Reason, nil
+}
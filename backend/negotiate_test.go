@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsMediaType(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept []string // One Accept header per entry
+		want   bool
+	}{
+		{"no header", nil, false},
+		{"listed", []string{"image/avif,image/webp,*/*;q=0.8"}, true},
+		{"with weight", []string{"image/webp;q=0.9, image/png"}, true},
+		{"zero weight", []string{"image/webp;q=0, */*"}, false},
+		{"invalid weight", []string{"image/webp;q=x"}, false},
+		{"wildcard only", []string{"*/*"}, false},
+		{"type wildcard only", []string{"image/*"}, false},
+		{"other types", []string{"image/png, image/jpeg"}, false},
+		{"case-insensitive", []string{"Image/WebP"}, true},
+		{"second header", []string{"text/html", "image/webp"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/images/file/a.jpg", nil)
+			for _, v := range tt.accept {
+				r.Header.Add("Accept", v)
+			}
+			if got := acceptsMediaType(r, "image/webp"); got != tt.want {
+				t.Errorf("acceptsMediaType(%q) = %v, want %v", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
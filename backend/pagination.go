@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imagePageVersion is ImagePage's api_version. It is raised whenever a
+// change to the envelope could break a client written against the previous
+// one; purely additive fields do not need a new version.
+const imagePageVersion = 1
+
+// cursorTimeLayout matches the microsecond precision of Postgres timestamps,
+// so a cursor compares equal to the row it was taken from.
+const cursorTimeLayout = "2006-01-02 15:04:05.999999"
+
+// ImagePage is returned by GET /api/images. Offset is omitted in cursor mode;
+// NextCursor is set while more images follow in uploaded_at order.
+type ImagePage struct {
+	APIVersion int             `json:"api_version"`
+	Images     []ImageMetadata `json:"images"`
+	Limit      int             `json:"limit"`
+	Clamped    bool            `json:"clamped"` // The requested limit exceeded MAX_PAGE_SIZE and Limit was lowered to it
+	Offset     *int            `json:"offset,omitempty"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// imageCursor is the keyset position of an image in uploaded_at order.
+type imageCursor struct {
+	UploadedAt time.Time
+	ID         int
+}
+
+// encodeImageCursor returns the opaque ?after= value for the position of img.
+func encodeImageCursor(img ImageMetadata) string {
+	raw := img.UploadedAt.Format(cursorTimeLayout) + "|" + strconv.Itoa(img.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeImageCursor(s string) (imageCursor, error) {
+	invalid := errors.New("invalid cursor")
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return imageCursor{}, invalid
+	}
+	ts, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return imageCursor{}, invalid
+	}
+	uploadedAt, err := time.Parse(cursorTimeLayout, ts)
+	if err != nil {
+		return imageCursor{}, invalid
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return imageCursor{}, invalid
+	}
+	return imageCursor{UploadedAt: uploadedAt, ID: id}, nil
+}
+
+// parseLimitOffset reads ?limit= (default def) and ?offset=, writing a 400
+// response and returning false if either is invalid. A limit above max is
+// lowered to max rather than refused, and clamped reports that it was.
+func parseLimitOffset(w http.ResponseWriter, r *http.Request, def, max int) (limit, offset int, clamped, ok bool) {
+	limit = def
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return 0, 0, false, false
+		}
+		limit, clamped = min(n, max), n > max
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return 0, 0, false, false
+		}
+		offset = n
+	}
+	return limit, offset, clamped, true
+}
+
+// setPaginationHeaders sets X-Total-Count and an RFC 8288 Link header for a
+// page of GET /api/images, so generic clients can paginate without reading
+// the body. Links keep the request's other query parameters. In cursor mode
+// only first and next can be expressed.
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, total int, page ImagePage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	link := func(rel string, set func(q url.Values)) string {
+		q := r.URL.Query()
+		q.Del("after")
+		q.Del("offset")
+		q.Set("limit", strconv.Itoa(page.Limit))
+		set(q)
+		u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+	}
+	withOffset := func(offset int) func(q url.Values) {
+		return func(q url.Values) {
+			if offset > 0 {
+				q.Set("offset", strconv.Itoa(offset))
+			}
+		}
+	}
+
+	links := []string{link("first", withOffset(0))}
+	if page.Offset == nil {
+		if page.NextCursor != "" {
+			links = append(links, link("next", func(q url.Values) { q.Set("after", page.NextCursor) }))
+		}
+	} else {
+		offset, limit := *page.Offset, page.Limit
+		if offset > 0 {
+			links = append(links, link("prev", withOffset(max(0, offset-limit))))
+		}
+		if offset+limit < total {
+			links = append(links, link("next", withOffset(offset+limit)))
+		}
+		last := 0
+		if total > 0 {
+			last = (total - 1) / limit * limit
+		}
+		links = append(links, link("last", withOffset(last)))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestImageCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		uploadedAt time.Time
+		id         int
+	}{
+		{"whole seconds", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), 1},
+		{"microseconds", time.Date(2024, 3, 1, 12, 0, 0, 123456000, time.UTC), 42},
+		{"large ID", time.Date(1999, 12, 31, 23, 59, 59, 999999000, time.UTC), 2147483647},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := decodeImageCursor(encodeImageCursor(ImageMetadata{ID: tt.id, UploadedAt: tt.uploadedAt}))
+			if err != nil {
+				t.Fatalf("decodeImageCursor: %v", err)
+			}
+			if cursor.ID != tt.id || !cursor.UploadedAt.Equal(tt.uploadedAt) {
+				t.Errorf("decoded %v/%d, want %v/%d", cursor.UploadedAt, cursor.ID, tt.uploadedAt, tt.id)
+			}
+		})
+	}
+}
+
+func TestDecodeImageCursorInvalid(t *testing.T) {
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("2024-03-01 12:00:00|12"))},
+		{"no separator", enc("2024-03-01 12:00:00")},
+		{"bad time", enc("yesterday|1")},
+		{"RFC 3339 time", enc("2024-03-01T12:00:00Z|1")},
+		{"bad ID", enc("2024-03-01 12:00:00|one")},
+		{"missing ID", enc("2024-03-01 12:00:00|")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cursor, err := decodeImageCursor(tt.cursor); err == nil {
+				t.Errorf("decodeImageCursor(%q) = %+v, want an error", tt.cursor, cursor)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+)
+
+// processedUpload is an accepted upload as it moves through its processing
+// pipeline. Steps may replace the stored file, in which case they update
+// DiskFilename, ContentType and Size and remove the file they replaced.
+type processedUpload struct {
+	Root         string
+	DiskFilename string
+	ContentType  string
+	Size         int64
+
+	ExifStripped  bool
+	Width, Height *int
+	ThumbFilename sql.NullString
+	PHash         sql.NullInt64
+}
+
+func (u *processedUpload) path() string { return storagePath(u.Root, u.DiskFilename) }
+
+// processStep is one stage of a pipeline. An error rejects the upload;
+// best-effort stages log their failures and return nil instead.
+type processStep func(u *processedUpload) error
+
+// Processor is the post-processing pipeline for one content type, run after
+// an upload has been validated, deduplicated and moderated.
+type Processor []processStep
+
+// Process runs the steps in order, stopping at the first error.
+func (p Processor) Process(u *processedUpload) error {
+	for _, step := range p {
+		if err := step(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadProcessors maps detected content types to their pipelines. Allowed
+// types without an entry are stored exactly as uploaded, with no dimensions,
+// thumbnail or perceptual hash.
+var uploadProcessors = map[string]Processor{
+	// JPEGs carry EXIF, and are lossy already, so re-encoding to WebP
+	// costs little.
+	"image/jpeg": {stripEXIFStep, convertToWebPStep, describeImageStep},
+	// PNG transparency survives WebP, which keeps an alpha channel.
+	"image/png": {convertToWebPStep, describeImageStep},
+	// GIFs are never converted: image/gif decodes only the first frame, so
+	// animations would be lost.
+	"image/gif":  {describeImageStep},
+	"image/webp": {describeImageStep},
+	// HEIC is transcoded first when HEIC_TRANSCODE is on, after which it is
+	// handled like any JPEG.
+	"image/heic": {heifToJPEGStep, stripEXIFStep, convertToWebPStep, describeImageStep},
+	"image/heif": {heifToJPEGStep, stripEXIFStep, convertToWebPStep, describeImageStep},
+}
+
+// processUpload runs the pipeline for u.ContentType, if there is one.
+func processUpload(u *processedUpload) error {
+	return uploadProcessors[u.ContentType].Process(u)
+}
+
+// stripEXIFStep drops EXIF/GPS metadata when STRIP_EXIF is on. A failure
+// rejects the upload rather than storing metadata the operator asked to
+// remove.
+func stripEXIFStep(u *processedUpload) error {
+	if !cfg.StripEXIF || u.ContentType != "image/jpeg" {
+		return nil
+	}
+	stripped, err := stripJPEGMetadata(u.path())
+	if err != nil {
+		return err
+	}
+	if stripped {
+		u.ExifStripped = true
+		u.Size = sizeOnDisk(u.path())
+	}
+	return nil
+}
+
+// convertToWebPStep stores a WebP re-encoding instead of the uploaded bytes
+// when CONVERT_TO_WEBP is on. The content hash still describes the upload so
+// re-uploads are detected. A failed conversion keeps the original.
+func convertToWebPStep(u *processedUpload) error {
+	if !cfg.ConvertToWebP {
+		return nil
+	}
+	converted, err := convertFileToWebP(u.Root, u.DiskFilename)
+	if err != nil {
+		log.Printf("Warning: WebP conversion of %s failed, storing original: %v", u.DiskFilename, err)
+		return nil
+	}
+	os.Remove(u.path())
+	u.DiskFilename = converted.DiskFilename
+	u.ContentType = converted.ContentType
+	u.Size = converted.Size
+	return nil
+}
+
+// describeImageStep records dimensions and the perceptual hash and, with
+// THUMBNAILS_ON_UPLOAD, generates the thumbnail; otherwise that is left to
+// the prewarm worker. All of it is best effort: files that turn out not to
+// be decodable get none of it.
+func describeImageStep(u *processedUpload) error {
+	u.Width, u.Height = imageDimensions(u.path())
+	if u.Width == nil {
+		return nil
+	}
+	u.PHash = perceptualHashFile(u.path())
+	if cfg.ThumbnailsOnUpload {
+		if name, err := generateThumbnail(u.Root, u.DiskFilename); err != nil {
+			log.Printf("Warning: failed to generate thumbnail for %s: %v", u.DiskFilename, err)
+		} else {
+			u.ThumbFilename = sql.NullString{String: name, Valid: true}
+		}
+	}
+	return nil
+}
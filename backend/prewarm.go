@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// runThumbnailPrewarmer generates missing thumbnails in the background every
+// THUMBNAIL_PREWARM_INTERVAL, at most THUMBNAIL_PREWARM_RATE per second, so
+// uploads with THUMBNAILS_ON_UPLOAD=false get thumbnails eventually without
+// a CPU spike. The returned channel is closed once the worker has stopped.
+func runThumbnailPrewarmer(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Images that failed once are not retried until restart; a file that
+		// cannot be decoded would otherwise be attempted every pass.
+		failed := make(map[int]bool)
+		ticker := time.NewTicker(cfg.ThumbnailPrewarmInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := prewarmThumbnails(ctx, failed); err != nil && ctx.Err() == nil {
+					log.Printf("Thumbnail prewarm pass failed: %v", err)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// prewarmThumbnails works through every decodable image without a thumbnail,
+// in ID order and in batches.
+func prewarmThumbnails(ctx context.Context, failed map[int]bool) error {
+	limiter := time.NewTicker(time.Second / time.Duration(cfg.ThumbnailPrewarmRate))
+	defer limiter.Stop()
+
+	countCtx, cancel := dbContext(ctx)
+	var pendingCount int64
+	err := dbQueryRow(countCtx, "SELECT COUNT(*) FROM images WHERE thumb_filename IS NULL AND width IS NOT NULL").Scan(&pendingCount)
+	cancel()
+	if err != nil {
+		return err
+	}
+	thumbnailsPending.Set(pendingCount)
+
+	lastID := 0
+	for {
+		type pending struct {
+			id       int
+			root     string
+			diskName string
+		}
+		queryCtx, cancel := dbContext(ctx)
+		rows, err := dbQuery(queryCtx, "SELECT id, COALESCE(storage_root, ''), disk_filename FROM images WHERE thumb_filename IS NULL AND width IS NOT NULL AND id > $1 ORDER BY id LIMIT $2", lastID, thumbnailBatchSize)
+		if err != nil {
+			cancel()
+			return err
+		}
+		var batch []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.root, &p.diskName); err != nil {
+				rows.Close()
+				cancel()
+				return err
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		cancel()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, p := range batch {
+			lastID = p.id
+			thumbnailsPending.Add(-1)
+			if failed[p.id] {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-limiter.C:
+			}
+			err := replaceThumbnail(ctx, p.id, p.root, p.diskName, sql.NullString{})
+			if errors.Is(err, errThumbnailSuperseded) {
+				continue // Rotated, replaced or deleted meanwhile, so this thumbnail is not wanted
+			}
+			if err != nil {
+				log.Printf("Thumbnail prewarm failed for image %d (%s): %v", p.id, p.diskName, err)
+				failed[p.id] = true
+				thumbnailsPrewarmFailed.Add(1)
+			} else {
+				thumbnailsPrewarmed.Add(1)
+			}
+		}
+	}
+}
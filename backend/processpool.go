@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// processingPool runs CPU-heavy image work (decoding, re-encoding, thumbnail
+// generation) on a fixed number of worker goroutines, so a burst of uploads
+// queues for CPU rather than decoding all at once. Everything around the
+// work, such as receiving the file and writing the row, stays on the
+// request's own goroutine.
+type processingPool struct {
+	jobs chan func()
+}
+
+var imageProcessing *processingPool // Sized from IMAGE_PROCESSING_WORKERS in main
+
+// newProcessingPool starts a pool of n workers. The workers run for the life
+// of the process.
+func newProcessingPool(n int) *processingPool {
+	p := &processingPool{jobs: make(chan func())}
+	for i := 0; i < n; i++ {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// Run waits for a free worker, runs fn on it and returns once fn has
+// finished. If ctx ends while still queued fn is not run and ctx.Err() is
+// returned; once started, fn always runs to completion. A panic in fn is
+// recovered on the worker and raised again from Run, on the caller's
+// goroutine, where recoverMiddleware can turn it into a 500.
+func (p *processingPool) Run(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	var panicked *workerPanic
+	imageProcessingQueued.Add(1)
+	select {
+	case p.jobs <- func() {
+		imageProcessingQueued.Add(-1)
+		defer close(done)
+		defer func() {
+			if v := recover(); v != nil {
+				panicked = &workerPanic{value: v, stack: debug.Stack()}
+			}
+		}()
+		fn()
+	}:
+	case <-ctx.Done():
+		imageProcessingQueued.Add(-1)
+		return ctx.Err()
+	}
+	<-done
+	if panicked != nil {
+		panic(panicked)
+	}
+	return nil
+}
+
+// workerPanic carries a panic out of a pool worker, keeping the worker's
+// stack, which the re-panic in Run would otherwise lose.
+type workerPanic struct {
+	value any
+	stack []byte
+}
+
+func (p *workerPanic) Error() string {
+	return fmt.Sprintf("%v\n[image processing worker]\n%s", p.value, p.stack)
+}
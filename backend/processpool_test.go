@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProcessingPoolRun(t *testing.T) {
+	p := newProcessingPool(1)
+	ran := false
+	if err := p.Run(context.Background(), func() { ran = true }); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !ran {
+		t.Fatal("fn did not run")
+	}
+}
+
+func TestProcessingPoolRunCancelledWhileQueued(t *testing.T) {
+	p := newProcessingPool(1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.Run(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	defer close(release)
+
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{"canceled", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, context.Canceled},
+		{"deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 10*time.Millisecond)
+		}, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			ran := false
+			err := p.Run(ctx, func() { ran = true })
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Run = %v, want %v", err, tt.want)
+			}
+			if ran {
+				t.Error("fn ran although its context ended while queued")
+			}
+		})
+	}
+}
+
+func TestProcessingPoolRunPanic(t *testing.T) {
+	p := newProcessingPool(1)
+	func() {
+		defer func() {
+			v := recover()
+			wp, ok := v.(*workerPanic)
+			if !ok {
+				t.Fatalf("recovered %v (%T), want *workerPanic", v, v)
+			}
+			if wp.value != "bad decoder" {
+				t.Errorf("panic value = %v, want %q", wp.value, "bad decoder")
+			}
+		}()
+		p.Run(context.Background(), func() { panic("bad decoder") })
+		t.Fatal("Run returned normally after fn panicked")
+	}()
+
+	// The worker survives the panic.
+	if err := p.Run(context.Background(), func() {}); err != nil {
+		t.Fatalf("Run after panic: %v", err)
+	}
+}
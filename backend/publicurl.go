@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// externalURL turns path, an absolute path on this server such as
+// "/api/images/1", into the URL clients use to reach it. PUBLIC_BASE_URL
+// wins when set, and may include a path prefix added by the gateway.
+// Otherwise X-Forwarded-Proto and X-Forwarded-Host are honoured when the
+// direct peer is a trusted proxy, so links survive TLS termination; from
+// anyone else they are ignored, or a client could point the links it is
+// given elsewhere.
+func externalURL(r *http.Request, path string) string {
+	if cfg.PublicBaseURL != "" {
+		return cfg.PublicBaseURL + path
+	}
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if fromTrustedProxy(r, cfg.TrustedProxies) {
+		if proto := strings.ToLower(firstForwardedValue(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwdHost := firstForwardedValue(r.Header.Get("X-Forwarded-Host")); validForwardedHost(fwdHost) {
+			host = fwdHost
+		}
+	}
+	return (&url.URL{Scheme: scheme, Host: host}).String() + path
+}
+
+// fromTrustedProxy reports whether the direct peer of r is in trustedProxies.
+func fromTrustedProxy(r *http.Request, trustedProxies []*net.IPNet) bool {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	ip := net.ParseIP(remote)
+	return ip != nil && ipInNets(ip, trustedProxies)
+}
+
+// firstForwardedValue returns the first entry of a comma-separated
+// X-Forwarded-* header, the one added by the proxy nearest the client.
+func firstForwardedValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+// validForwardedHost accepts a host with an optional port and nothing else,
+// so a forwarded value cannot smuggle a path or userinfo into a URL.
+func validForwardedHost(host string) bool {
+	if host == "" || strings.ContainsAny(host, "/\\@?# ") {
+		return false
+	}
+	u, err := url.Parse("http://" + host)
+	return err == nil && u.Host == host
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// staleTempAge is how old a leftover *.tmp file must be before the purge
+// worker treats it as abandoned rather than still being written.
+const staleTempAge = time.Hour
+
+// purgeTask removes one category of expired items and reports how many it
+// removed. A task that fails part-way still reports what it did remove.
+type purgeTask struct {
+	name string
+	run  func(ctx context.Context, now time.Time) (int, error)
+}
+
+// purgeTasks lists every category the purge worker cleans up.
+var purgeTasks = []purgeTask{
+	{name: "derived_images", run: purgeDerivedImages},
+	{name: "finished_jobs", run: purgeFinishedJobs},
+	{name: "multipart_spills", run: purgeMultipartSpills},
+}
+
+// runPurgeWorker runs every purge task each interval until ctx is done. A
+// failing task is logged and retried on the next tick without holding up the
+// others. The returned channel is closed once the worker has stopped.
+func runPurgeWorker(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				runPurgeTasks(ctx, now)
+			}
+		}
+	}()
+	return done
+}
+
+func runPurgeTasks(ctx context.Context, now time.Time) {
+	for _, task := range purgeTasks {
+		if ctx.Err() != nil {
+			return
+		}
+		removed, err := task.run(ctx, now)
+		purgedItems.Add(task.name, int64(removed))
+		if err != nil {
+			purgeFailures.Add(1)
+			log.Printf("Purge %s: removed %d before failing: %v", task.name, removed, err)
+		} else if removed > 0 {
+			log.Printf("Purge %s: removed %d", task.name, removed)
+		}
+	}
+}
+
+// purgeDerivedImages deletes cached resized variants older than
+// DERIVED_CACHE_TTL, which are regenerated on the next request, and
+// temporary files abandoned by interrupted resizes.
+func purgeDerivedImages(ctx context.Context, now time.Time) (int, error) {
+	removed := 0
+	var firstErr error
+	err := filepath.WalkDir(filepath.Join(uploadPath, derivedDir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Removed concurrently, e.g. by the image's deletion.
+			return nil
+		}
+		maxAge := cfg.DerivedCacheTTL
+		if strings.HasSuffix(d.Name(), ".tmp") {
+			maxAge = staleTempAge
+		}
+		if now.Sub(info.ModTime()) < maxAge {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			// Keep going; one stuck file should not block the rest.
+			if firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		}
+		removed++
+		return nil
+	})
+	if err == nil {
+		err = firstErr
+	}
+	return removed, err
+}
+
+// purgeFinishedJobs drops background jobs that finished more than
+// JOB_RETENTION ago, so the in-memory registry does not grow forever.
+func purgeFinishedJobs(ctx context.Context, now time.Time) (int, error) {
+	return backgroundJobs.PurgeFinished(now.Add(-cfg.JobRetention)), nil
+}
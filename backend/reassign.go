@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+const maxReassignImages = 1000
+
+// ReassignRequest is the body of POST /api/admin/images/reassign.
+type ReassignRequest struct {
+	IDs         []int  `json:"ids"`
+	NewOwnerOID string `json:"new_owner_oid"` // Principal ID, as recorded in images.owner
+}
+
+// ReassignResponse reports how many images changed owner.
+type ReassignResponse struct {
+	Affected int `json:"affected"`
+}
+
+// reassignImagesHandler moves images to another owner in one transaction.
+// The new owner must be a configured principal, since owners are the
+// principals of API keys. Images the new owner already holds are not
+// counted. It is wrapped in requireAdmin.
+func reassignImagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	var req ReassignRequest
+	if !decodeJSONBody(w, r, &req, "ids", "new_owner_oid") {
+		return
+	}
+	imageIDs := uniqueIDs(req.IDs)
+	if len(imageIDs) == 0 || len(imageIDs) > maxReassignImages {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("ids must contain between 1 and %d images", maxReassignImages))
+		return
+	}
+	if !isKnownPrincipal(req.NewOwnerOID) {
+		writeError(w, http.StatusBadRequest, "new_owner_oid is not the ID of a configured principal")
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	missing, err := missingImageIDs(ctx, imageIDs)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying images from database: "+err.Error())
+		return
+	}
+	if len(missing) > 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Images not found: %v", missing))
+		return
+	}
+
+	tx, err := dbBegin(ctx)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error starting transaction: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	changed := make(map[int]bool)
+	err = collectImageIDs(ctx, tx, changed,
+		"UPDATE images SET owner = $1 WHERE id = ANY($2) AND owner IS DISTINCT FROM $1 RETURNING id",
+		req.NewOwnerOID, pq.Array(imageIDs))
+	if err != nil {
+		if isUniqueViolation(err, "images_owner_content_sha256_key") {
+			writeError(w, http.StatusConflict, "The new owner already has an image identical to one of these")
+			return
+		}
+		writeError(w, dbErrorStatus(err), "Error reassigning images: "+err.Error())
+		return
+	}
+	affected := make([]int, 0, len(changed))
+	for _, id := range imageIDs {
+		if changed[id] {
+			affected = append(affected, id)
+		}
+	}
+	if len(affected) > 0 {
+		if err := recordAudit(ctx, tx, r, auditActionReassign, affected); err != nil {
+			writeError(w, dbErrorStatus(err), "Error recording audit entry: "+err.Error())
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error committing reassignment: "+err.Error())
+		return
+	}
+	for _, id := range affected {
+		imageCache.InvalidateID(id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReassignResponse{Affected: len(affected)})
+}
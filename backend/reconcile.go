@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const reconcileSampleSize = 100 // Names listed per category; the counts are always complete
+
+// ReconcileReport compares the images table with the files in the upload
+// roots.
+type ReconcileReport struct {
+	MissingFiles       int      `json:"missing_files"`        // Rows whose file is not on disk
+	MissingFilesSample []string `json:"missing_files_sample"` // Their disk_filename values
+	OrphanFiles        int      `json:"orphan_files"`         // Files with no images row
+	OrphanFilesSample  []string `json:"orphan_files_sample"`  // Their paths, relative for files in uploadPath
+}
+
+// reconcileHandler handles GET /api/admin/reconcile. It only reports
+// differences; nothing is deleted or repaired.
+func reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	known, err := loadDiskFilenames(r)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+
+	report := ReconcileReport{MissingFilesSample: []string{}, OrphanFilesSample: []string{}}
+	onDisk := make(map[string]bool, len(known))
+	for _, root := range uploadRoots() {
+		if err := scanUploadRoot(root, known, onDisk, &report); err != nil {
+			writeError(w, http.StatusInternalServerError, "Error scanning upload directory: "+err.Error())
+			return
+		}
+	}
+
+	for path, name := range known {
+		if !onDisk[path] {
+			report.MissingFiles++
+			report.MissingFilesSample = appendSample(report.MissingFilesSample, name)
+		}
+	}
+	sort.Strings(report.MissingFilesSample)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// scanUploadRoot records every stored file under root in onDisk, keyed by
+// full path, and reports those not in known as orphans.
+func scanUploadRoot(root string, known map[string]string, onDisk map[string]bool, report *ReconcileReport) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if root == uploadPath && (rel == thumbnailDir || rel == derivedDir) || isTempDir(d, path) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		// Health check probes and in-progress writes are not stored images.
+		if strings.HasPrefix(d.Name(), ".") || strings.HasSuffix(d.Name(), ".tmp") {
+			return nil
+		}
+		onDisk[path] = true
+		if _, ok := known[path]; !ok {
+			if root != uploadPath {
+				rel = path
+			}
+			report.OrphanFiles++
+			report.OrphanFilesSample = appendSample(report.OrphanFilesSample, rel)
+		}
+		return nil
+	})
+}
+
+func appendSample(sample []string, name string) []string {
+	if len(sample) < reconcileSampleSize {
+		sample = append(sample, name)
+	}
+	return sample
+}
+
+// loadDiskFilenames returns the disk_filename of every image, keyed by the
+// full path of its file.
+func loadDiskFilenames(r *http.Request) (map[string]string, error) {
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	rows, err := dbQuery(ctx, "SELECT COALESCE(storage_root, ''), disk_filename FROM images")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var root, name string
+		if err := rows.Scan(&root, &name); err != nil {
+			return nil, err
+		}
+		names[storagePath(root, name)] = name
+	}
+	return names, rows.Err()
+}
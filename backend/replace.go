@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// replaceImageFileHandler handles PUT /api/images/{id}/file: a multipart
+// upload, validated, moderated and processed exactly like a new one, that
+// takes the place of the image's file. The ID, display name, tags, album and
+// favorites all stay with the image.
+func replaceImageFileHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	if r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "Only PUT method is allowed")
+		return
+	}
+
+	// Checked before the body is read, so a refused request costs no upload.
+	var oldRoot, oldFilename string
+	var oldThumb, owner sql.NullString
+	lookupCtx, cancelLookup := dbContext(r.Context())
+	err := dbQueryRow(lookupCtx, "SELECT COALESCE(storage_root, ''), disk_filename, thumb_filename, owner FROM images WHERE id = $1", imageID).Scan(&oldRoot, &oldFilename, &oldThumb, &owner)
+	cancelLookup()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	if !mayModifyImage(r, owner) {
+		writeError(w, http.StatusForbidden, "Only the uploader of this image or an admin may replace it")
+		return
+	}
+
+	received, ok := receiveUpload(w, r)
+	if !ok {
+		return
+	}
+	defer releaseUploadSlot()
+
+	lookupCtx, cancelLookup = dbContext(r.Context())
+	existingID, err := findImageIDByHash(lookupCtx, owner, received.ContentHash)
+	cancelLookup()
+	if err != nil {
+		os.Remove(received.path())
+		writeError(w, dbErrorStatus(err), "Error checking for duplicate image: "+err.Error())
+		return
+	}
+	if existingID == imageID {
+		// The same bytes again: nothing to replace.
+		os.Remove(received.path())
+		writeImageMetadata(w, r, imageID)
+		return
+	}
+	if existingID != 0 {
+		os.Remove(received.path())
+		writeReplaceConflict(w, existingID)
+		return
+	}
+
+	upload, ok := moderateAndProcessUpload(w, r, received)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	result, err := dbExec(ctx,
+		"UPDATE images SET disk_filename = $1, content_type = $2, size = $3, content_sha256 = $4, width = $5, height = $6, thumb_filename = $7, phash = $8, storage_root = $9, exif_stripped = $10 WHERE id = $11",
+		upload.DiskFilename, upload.ContentType, upload.Size, received.ContentHash, upload.Width, upload.Height, upload.ThumbFilename, upload.PHash, upload.Root, upload.ExifStripped, imageID,
+	)
+	if err == nil {
+		if n, _ := result.RowsAffected(); n == 0 {
+			err = sql.ErrNoRows
+		}
+	}
+	if err != nil {
+		os.Remove(upload.path())
+		removeThumbnail(upload.ThumbFilename)
+		switch {
+		case err == sql.ErrNoRows:
+			writeError(w, http.StatusNotFound, "Image not found") // Deleted while the upload was in progress
+		case isUniqueViolation(err, "images_owner_content_sha256_key"):
+			// A concurrent upload of the same bytes won the race.
+			if existingID, lookupErr := findImageIDByHash(ctx, owner, received.ContentHash); lookupErr == nil && existingID != 0 {
+				writeReplaceConflict(w, existingID)
+				return
+			}
+			writeError(w, http.StatusConflict, "An identical image has already been uploaded")
+		default:
+			writeError(w, dbErrorStatus(err), "Error updating image metadata: "+err.Error())
+		}
+		return
+	}
+	imageCache.InvalidateID(imageID)
+
+	oldPath := storagePath(oldRoot, oldFilename)
+	if err := os.Remove(oldPath); err != nil {
+		log.Printf("Warning: failed to delete replaced image file %s: %v", oldPath, err)
+	}
+	removeDerivedImages(oldFilename)
+	removeThumbnail(oldThumb)
+	publishImageEvent(eventImageReplaced, imageID, upload.DiskFilename)
+
+	writeImageMetadata(w, r, imageID)
+}
+
+// mayModifyImage reports whether the caller may change an image uploaded by
+// owner, such as replacing its file. Admins may modify any image; anonymous
+// uploads, having no owner to protect, may be modified by anyone. Training
+// jobs, which record the principal that started them, follow the same rule.
+func mayModifyImage(r *http.Request, owner sql.NullString) bool {
+	if !owner.Valid {
+		return true
+	}
+	p := principalFromContext(r.Context())
+	return p != nil && (p.Admin || p.ID == owner.String)
+}
+
+// ownedImagesCondition returns a WHERE condition limiting a query on images
+// to those the caller uploaded, with placeholder argCount+1 for its argument
+// if it has one. Admins get no condition; anonymous callers get the
+// anonymous uploads.
+func ownedImagesCondition(r *http.Request, argCount int) (condition string, args []any) {
+	p := principalFromContext(r.Context())
+	switch {
+	case p == nil:
+		return "owner IS NULL", nil
+	case p.Admin:
+		return "", nil
+	}
+	return "owner = $" + strconv.Itoa(argCount+1), []any{p.ID}
+}
+
+// writeReplaceConflict answers a replacement, or a rotation, whose content is
+// already stored as another image. Unlike a new upload, DEDUP_MODE=return does not apply:
+// the other image cannot stand in for this one.
+func writeReplaceConflict(w http.ResponseWriter, existingID int) {
+	w.Header().Set("Content-Type", "application/json")
+	retryable := false
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(SimpleResponse{Error: "An identical image has already been uploaded", Code: errorCode(http.StatusConflict), ID: existingID, Retryable: &retryable})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+const derivedDir = "derived" // Subdirectory of uploadPath caching resized variants
+
+var resizeFlights singleflight.Group // Keyed by derived path, so concurrent misses generate a variant once
+
+// parseResizeParams reads the optional ?w= and ?h= parameters. A missing
+// dimension is unconstrained. ok is false when neither is given.
+func parseResizeParams(r *http.Request) (width, height int, ok bool, err error) {
+	parse := func(name string) (int, error) {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > cfg.MaxResizeDimension {
+			return 0, fmt.Errorf("%s must be an integer between 1 and %d", name, cfg.MaxResizeDimension)
+		}
+		return n, nil
+	}
+	if width, err = parse("w"); err != nil {
+		return 0, 0, false, err
+	}
+	if height, err = parse("h"); err != nil {
+		return 0, 0, false, err
+	}
+	return width, height, width != 0 || height != 0, nil
+}
+
+// derivedPath returns where the variant of diskFilename resized to fit
+// width×height is cached. A zero dimension means unconstrained.
+func derivedPath(diskFilename string, width, height int) string {
+	base := filepath.Base(diskFilename)
+	ext := filepath.Ext(base)
+	name := fmt.Sprintf("%s_%dx%d%s", strings.TrimSuffix(base, ext), width, height, ext)
+	return filepath.Join(uploadPath, derivedDir, name)
+}
+
+// serveResizedImage serves img scaled down to fit width×height, generating and
+// caching the variant on first request. Derived files are keyed by the
+// immutable disk filename, so clients may cache them indefinitely.
+func serveResizedImage(w http.ResponseWriter, r *http.Request, img ImageMetadata, width, height int) {
+	encode, ok := imageEncoders[img.ContentType]
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, "Resizing is not supported for content type "+img.ContentType)
+		return
+	}
+
+	path := derivedPath(img.DiskFilename, width, height)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// Requests missing the same variant share one generation, on the
+		// image processing pool. It is detached from the request that
+		// started it, which may go away while the others still wait.
+		status, err, _ := resizeFlights.Do(path, func() (any, error) {
+			var status int
+			var err error
+			imageProcessing.Run(context.WithoutCancel(r.Context()), func() {
+				status, err = writeResizedVariant(img, path, width, height, encode)
+			})
+			return status, err
+		})
+		if err != nil {
+			writeError(w, status.(int), err.Error())
+			return
+		}
+	}
+
+	cacheScope := "public"
+	if urlSigningEnabled() {
+		cacheScope = "private"
+	}
+	w.Header().Set("Cache-Control", cacheScope+", max-age=31536000, immutable")
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("ETag", `"`+filepath.Base(path)+`"`)
+	http.ServeFile(w, r, path)
+}
+
+// writeResizedVariant scales the original of img to fit width×height and
+// caches it at path. On failure it returns the status to answer with and an
+// error whose text is the message.
+func writeResizedVariant(img ImageMetadata, path string, width, height int, encode func(io.Writer, image.Image) error) (int, error) {
+	src, err := decodeImageFile(storagePath(img.StorageRoot, img.DiskFilename))
+	if err != nil {
+		return http.StatusUnprocessableEntity, fmt.Errorf("Error decoding image: %w", err)
+	}
+	b := src.Bounds()
+	maxW, maxH := width, height
+	if maxW == 0 {
+		maxW = b.Dx()
+	}
+	if maxH == 0 {
+		maxH = b.Dy()
+	}
+
+	// Write under a temporary name so concurrent requests never serve a
+	// partially written variant.
+	tmpPath := path + "." + uuid.New().String() + ".tmp"
+	if _, err := writeImageFile(tmpPath, scaleToBox(src, maxW, maxH), encode); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Error writing resized image: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return http.StatusInternalServerError, fmt.Errorf("Error caching resized image: %w", err)
+	}
+	return 0, nil
+}
+
+// removeDerivedImages deletes every cached variant of diskFilename.
+func removeDerivedImages(diskFilename string) {
+	for _, path := range derivedImagePaths(diskFilename) {
+		os.Remove(path)
+	}
+}
+
+// derivedImagePaths lists the cached variants of diskFilename.
+func derivedImagePaths(diskFilename string) []string {
+	base := filepath.Base(diskFilename)
+	ext := filepath.Ext(base)
+	pattern := filepath.Join(uploadPath, derivedDir, strings.TrimSuffix(base, ext)+"_*")
+	matches, _ := filepath.Glob(pattern)
+	return matches
+}
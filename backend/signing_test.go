@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// withConfig replaces cfg for the duration of the test.
+func withConfig(t *testing.T, c Config) {
+	t.Helper()
+	old := cfg
+	cfg = &c
+	t.Cleanup(func() { cfg = old })
+}
+
+func TestVerifyFileURL(t *testing.T) {
+	withConfig(t, Config{URLSigningKey: "test-key"})
+	valid, err := url.ParseQuery(signedQuery("a.jpg", time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := url.ParseQuery(signedQuery("a.jpg", time.Now().Add(-time.Minute)))
+	later := strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)
+	sig := valid.Get("signature")
+	tampered := "0" + sig[1:]
+	if sig[0] == '0' {
+		tampered = "1" + sig[1:]
+	}
+
+	tests := []struct {
+		name         string
+		diskFilename string
+		query        url.Values
+		want         bool
+	}{
+		{"valid", "a.jpg", valid, true},
+		{"other file", "b.jpg", valid, false},
+		{"expired", "a.jpg", expired, false},
+		{"no parameters", "a.jpg", url.Values{}, false},
+		{"no signature", "a.jpg", url.Values{"expires": valid["expires"]}, false},
+		{"expiry extended", "a.jpg", url.Values{"expires": {later}, "signature": valid["signature"]}, false},
+		{"signature tampered", "a.jpg", url.Values{"expires": valid["expires"], "signature": {tampered}}, false},
+		{"expiry not a number", "a.jpg", url.Values{"expires": {"soon"}, "signature": valid["signature"]}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyFileURL(tt.diskFilename, tt.query); got != tt.want {
+				t.Errorf("verifyFileURL(%q, %v) = %v, want %v", tt.diskFilename, tt.query, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("other key", func(t *testing.T) {
+		withConfig(t, Config{URLSigningKey: "another-key"})
+		if verifyFileURL("a.jpg", valid) {
+			t.Error("a signature made with another key was accepted")
+		}
+	})
+}
+
+func TestMayReadImageFile(t *testing.T) {
+	withConfig(t, Config{URLSigningKey: "test-key"})
+	signed := "?" + signedQuery("a.jpg", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name         string
+		signingKey   string
+		principal    bool
+		diskFilename string
+		query        string
+		want         bool
+	}{
+		{"signing off", "", false, "a.jpg", "", true},
+		{"unsigned", "test-key", false, "a.jpg", "", false},
+		{"signed", "test-key", false, "a.jpg", signed, true},
+		{"signed for another file", "test-key", false, "b.jpg", signed, false},
+		{"principal", "test-key", true, "a.jpg", "", true},
+		// Responses spanning many images cannot be signed.
+		{"many images, anonymous", "test-key", false, "", signed, false},
+		{"many images, principal", "test-key", true, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, Config{URLSigningKey: tt.signingKey})
+			r := httptest.NewRequest("GET", "/api/images/1/thumbnail"+tt.query, nil)
+			if tt.principal {
+				r = r.WithContext(context.WithValue(r.Context(), principalContextKey{}, &Principal{ID: "apikey:test", Admin: true}))
+			}
+			w := httptest.NewRecorder()
+			if got := mayReadImageFile(w, r, tt.diskFilename); got != tt.want {
+				t.Fatalf("mayReadImageFile = %v, want %v", got, tt.want)
+			}
+			if !tt.want && w.Code != http.StatusForbidden {
+				t.Errorf("refusal wrote status %d, want 403", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"image"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultSimilarityThreshold = 10  // Hamming distance out of 64 bits
+	maxSimilarImages           = 100 // Results returned by /similar
+)
+
+// SimilarImage is an entry of GET /api/images/{id}/similar. Distance is the
+// number of differing bits between the two perceptual hashes (0 to 64).
+type SimilarImage struct {
+	ImageMetadata
+	Distance int `json:"distance"`
+}
+
+// dHash computes a 64-bit difference hash of img: the image is reduced to a
+// 9×8 grid of average luminance, and each bit records whether a cell is
+// brighter than its right neighbour. Resized or re-encoded copies of an image
+// hash to the same or nearby values.
+func dHash(img image.Image) uint64 {
+	const cols, rows = 9, 8
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var grid [rows][cols]float64
+	for gy := 0; gy < rows; gy++ {
+		y0, y1 := b.Min.Y+gy*h/rows, b.Min.Y+(gy+1)*h/rows
+		y1 = max(y1, y0+1)
+		for gx := 0; gx < cols; gx++ {
+			x0, x1 := b.Min.X+gx*w/cols, b.Min.X+(gx+1)*w/cols
+			x1 = max(x1, x0+1)
+			var sum float64
+			for y := y0; y < y1 && y < b.Max.Y; y++ {
+				for x := x0; x < x1 && x < b.Max.X; x++ {
+					r, g, bl, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+				}
+			}
+			grid[gy][gx] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+
+	var hash uint64
+	for gy := 0; gy < rows; gy++ {
+		for gx := 0; gx < cols-1; gx++ {
+			hash <<= 1
+			if grid[gy][gx] > grid[gy][gx+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// perceptualHashFile returns the dHash of the image stored at path, or an
+// invalid value if the file cannot be decoded. The hash is stored as a signed
+// BIGINT, so the bits are reinterpreted rather than converted.
+func perceptualHashFile(path string) sql.NullInt64 {
+	img, err := decodeImageFile(path)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(dHash(img)), Valid: true}
+}
+
+// similarImagesHandler handles GET /api/images/{id}/similar?threshold=,
+// returning other images whose perceptual hash is within threshold bits of
+// this one's, closest first. Images without a hash (not decodable, or
+// uploaded before hashing and not yet backfilled) are never matched.
+func similarImagesHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	threshold := defaultSimilarityThreshold
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 64 {
+			writeError(w, http.StatusBadRequest, "threshold must be an integer between 0 and 64")
+			return
+		}
+		threshold = n
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var hash sql.NullInt64
+	var diskFilename string
+	err := dbQueryRow(ctx, "SELECT phash, disk_filename FROM images WHERE id = $1", imageID).Scan(&hash, &diskFilename)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	if !hash.Valid {
+		writeError(w, http.StatusUnprocessableEntity, "Image has no perceptual hash")
+		return
+	}
+
+	// Postgres 13 has no bit_count, so the differing bits are counted in the
+	// text form of the XOR.
+	rows, err := dbQuery(ctx, `
+		SELECT `+imageColumns+`, distance FROM (
+			SELECT *, length(replace((phash # $1)::bit(64)::text, '0', '')) AS distance
+			FROM images WHERE phash IS NOT NULL AND id <> $2
+		) AS images
+		WHERE distance <= $3
+		ORDER BY distance, id
+		LIMIT $4`,
+		hash.Int64, imageID, threshold, maxSimilarImages)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	similar := []SimilarImage{}
+	for rows.Next() {
+		var s SimilarImage
+		if err := rows.Scan(append(imageScanDest(&s.ImageMetadata), &s.Distance)...); err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+			return
+		}
+		similar = append(similar, s)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(similar)
+}
+
+// backfillPerceptualHashesHandler handles POST /api/admin/backfill-phash by
+// starting a background job that hashes images uploaded before perceptual
+// hashing existed.
+func backfillPerceptualHashesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+	job := backgroundJobs.Start("backfill-phash", backfillPerceptualHashes)
+	writeJobAccepted(w, r, job)
+}
+
+// backfillPerceptualHashes hashes every decodable image that has no hash yet,
+// in ID order and in batches. Files that cannot be decoded are counted as
+// failed and keep a NULL hash.
+func backfillPerceptualHashes(ctx context.Context, update func(func(*BackgroundJob))) error {
+	lastID := 0
+	for {
+		type pending struct {
+			id       int
+			root     string
+			diskName string
+		}
+		queryCtx, cancel := dbContext(ctx)
+		rows, err := dbQuery(queryCtx, "SELECT id, COALESCE(storage_root, ''), disk_filename FROM images WHERE phash IS NULL AND width IS NOT NULL AND id > $1 ORDER BY id LIMIT $2", lastID, thumbnailBatchSize)
+		if err != nil {
+			cancel()
+			return err
+		}
+		var batch []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.root, &p.diskName); err != nil {
+				rows.Close()
+				cancel()
+				return err
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		cancel()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, p := range batch {
+			lastID = p.id
+			hash := perceptualHashFile(storagePath(p.root, p.diskName))
+			if !hash.Valid {
+				log.Printf("Perceptual hash backfill failed for image %d (%s): not decodable", p.id, p.diskName)
+				update(func(j *BackgroundJob) { j.Failed++ })
+				continue
+			}
+			updateCtx, cancel := dbContext(ctx)
+			_, err := dbExec(updateCtx, "UPDATE images SET phash = $1 WHERE id = $2", hash, p.id)
+			cancel()
+			if err != nil {
+				return err
+			}
+			update(func(j *BackgroundJob) { j.Processed++ })
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// trainingSimulationSteps is how many updates a simulated job takes to go
+// from 0 to 100% progress, one every TRAINING_SIMULATION_DURATION / steps.
+const trainingSimulationSteps = 20
+
+// runTrainingSimulator stands in for a trainer when TRAINER_URL is not set,
+// so the SPA's progress display has something to show. Every tick it
+// advances all pending and running jobs by one step, moving them to running
+// and finally succeeded. Progress lives in the database, so concurrent jobs
+// need no per-job goroutine and a restart resumes where it left off. The
+// returned channel is closed once the worker has stopped, or at once when a
+// real trainer is configured.
+func runTrainingSimulator(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	if trainer != nil {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(max(cfg.TrainingSimulationDuration/trainingSimulationSteps, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := advanceSimulatedTrainingJobs(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Training simulation step failed: %v", err)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// advanceSimulatedTrainingJobs moves every unfinished job one step on and
+// wakes anyone waiting on them. A job that finishes gets a stub model
+// artifact in the same statement, so there is never a succeeded job without
+// one.
+func advanceSimulatedTrainingJobs(parent context.Context) error {
+	ctx, cancel := dbContext(parent)
+	defer cancel()
+	step := 100 / trainingSimulationSteps
+	// SET expressions see the row's old progress.
+	rows, err := dbQuery(ctx, `
+		WITH advanced AS (
+			UPDATE training_jobs SET
+				progress = LEAST(100, progress + $1),
+				status = CASE WHEN progress + $1 >= 100 THEN $2 ELSE $3 END
+			WHERE status IN ($4, $3)
+			RETURNING id, status, model_name, epochs
+		), artifacts AS (
+			INSERT INTO model_artifacts (job_id, location, metrics)
+			SELECT id, 'simulated/' || id || '/' || model_name, jsonb_build_object('simulated', true, 'epochs', epochs)
+			FROM advanced WHERE status = $2
+		)
+		SELECT id, status FROM advanced`,
+		step, trainingStatusSucceeded, trainingStatusRunning, trainingStatusPending,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var status string
+		if err := rows.Scan(&id, &status); err != nil {
+			return err
+		}
+		if status == trainingStatusSucceeded {
+			log.Printf("Simulated training job %d finished.", id)
+		}
+		trainingJobChanges.Notify(id)
+	}
+	return rows.Err()
+}
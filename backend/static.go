@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// rootHandler serves everything not matched by a more specific route: the
+// SPA when STATIC_DIR is set, or a greeting otherwise.
+func rootHandler() http.HandlerFunc {
+	if cfg.StaticDir == "" {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "Hello from Go Backend!")
+		}
+	}
+	return spaHandler(cfg.StaticDir)
+}
+
+// spaHandler serves the files under dir. Other paths are client-side routes
+// of the SPA and get index.html, except for unknown API routes, which keep a
+// JSON 404, and paths with a file extension, which are missing assets rather
+// than routes.
+func spaHandler(dir string) http.HandlerFunc {
+	root := http.Dir(dir)
+	files := http.FileServer(root)
+	index := filepath.Join(dir, "index.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusMethodNotAllowed, "Only GET and HEAD methods are allowed")
+			return
+		}
+		if r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/") {
+			writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+
+		// http.Dir refuses paths that would leave dir.
+		name := path.Clean(r.URL.Path)
+		if f, err := root.Open(name); err == nil {
+			info, err := f.Stat()
+			f.Close()
+			if err == nil && !info.IsDir() {
+				files.ServeHTTP(w, r)
+				return
+			}
+		}
+		if path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		// index.html names the current asset bundles, so it must be
+		// revalidated on every load.
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFile(w, r, index)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// ContentTypeStats aggregates the images sharing a content type.
+type ContentTypeStats struct {
+	ContentType string `json:"content_type"`
+	Count       int    `json:"count"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// StatsResponse is returned by GET /api/stats for the admin dashboard.
+type StatsResponse struct {
+	TotalImages   int                `json:"total_images"`
+	TotalBytes    int64              `json:"total_bytes"` // Sum of sizes recorded in the database
+	DiskBytes     int64              `json:"disk_bytes"`  // Actual bytes used under all upload roots
+	ByContentType []ContentTypeStats `json:"by_content_type"`
+	OldestUpload  *time.Time         `json:"oldest_upload,omitempty"`
+	NewestUpload  *time.Time         `json:"newest_upload,omitempty"`
+
+	// MostDownloaded lags behind by up to DOWNLOAD_FLUSH_INTERVAL.
+	MostDownloaded []ImageDownloads `json:"most_downloaded"`
+}
+
+// statsHandler reports aggregate storage usage. It is wrapped in requireAdmin.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	rows, err := dbQuery(ctx, `
+		SELECT COALESCE(content_type, ''), COUNT(*), COALESCE(SUM(size), 0), MIN(uploaded_at), MAX(uploaded_at)
+		FROM images
+		GROUP BY COALESCE(content_type, '')
+		ORDER BY COUNT(*) DESC`)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	stats := StatsResponse{ByContentType: []ContentTypeStats{}}
+	for rows.Next() {
+		var ct ContentTypeStats
+		var oldest, newest time.Time
+		if err := rows.Scan(&ct.ContentType, &ct.Count, &ct.Bytes, &oldest, &newest); err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+			return
+		}
+		stats.ByContentType = append(stats.ByContentType, ct)
+		stats.TotalImages += ct.Count
+		stats.TotalBytes += ct.Bytes
+		if stats.OldestUpload == nil || oldest.Before(*stats.OldestUpload) {
+			stats.OldestUpload = &oldest
+		}
+		if stats.NewestUpload == nil || newest.After(*stats.NewestUpload) {
+			stats.NewestUpload = &newest
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+
+	stats.MostDownloaded, err = mostDownloadedImages(ctx, mostDownloadedLimit)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying download counts: "+err.Error())
+		return
+	}
+
+	for _, root := range uploadRoots() {
+		size, err := dirSize(root)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Error measuring upload directory: "+err.Error())
+			return
+		}
+		stats.DiskBytes += size
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// dirSize returns the total size of the regular files under root.
+func dirSize(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
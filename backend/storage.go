@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload root selection policies, chosen with UPLOAD_PATH_POLICY.
+const (
+	uploadPolicyMostFree   = "most-free"   // Root with the most available bytes
+	uploadPolicyRoundRobin = "round-robin" // Roots in turn
+)
+
+// uploadRootCounter drives the round-robin policy.
+var uploadRootCounter atomic.Uint64
+
+// diskFilenamePattern matches stored file names: date-partitioned relative
+// paths ("2024/05/31/{uuid}.jpg") and the flat names used before
+// partitioning, which still live directly in their root.
+var diskFilenamePattern = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2}/)?[^/\\]+$`)
+
+// newDiskFilename returns a fresh "YYYY/MM/DD/{uuid}{ext}" name for a file
+// stored today under root, creating its partition directory. Spreading files
+// across per-day directories keeps any one directory small.
+func newDiskFilename(root, ext string) (string, error) {
+	partition := time.Now().UTC().Format("2006/01/02")
+	if err := os.MkdirAll(filepath.Join(storageRoot(root), filepath.FromSlash(partition)), os.ModePerm); err != nil {
+		return "", err
+	}
+	return partition + "/" + uuid.New().String() + ext, nil
+}
+
+const maxFilenameAttempts = 3 // Fresh names tried before giving up on a clash
+
+// createStoredFile creates a new, empty file under a fresh disk filename in
+// the root picked by UPLOAD_PATH_POLICY, and returns the root and name.
+// The file is opened exclusively so an existing file is never truncated; on
+// the (theoretical) clash another name is tried.
+func createStoredFile(ext string) (root, name string, f *os.File, err error) {
+	root = pickUploadRoot()
+	for attempt := 1; ; attempt++ {
+		name, err = newDiskFilename(root, ext)
+		if err != nil {
+			return "", "", nil, err
+		}
+		f, err = os.OpenFile(storagePath(root, name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if err == nil {
+			return root, name, f, nil
+		}
+		if !errors.Is(err, fs.ErrExist) || attempt >= maxFilenameAttempts {
+			return "", "", nil, err
+		}
+	}
+}
+
+// isDiskFull reports whether err comes from a full volume or an exhausted
+// quota.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// writeStorageError answers a failed write of an uploaded file. A full disk
+// gets 507 and a CRITICAL log line so it can be told apart from other I/O
+// errors; anything else is a 500.
+func writeStorageError(w http.ResponseWriter, msg string, err error) {
+	if isDiskFull(err) {
+		diskFullErrors.Add(1)
+		log.Printf("CRITICAL: upload storage is full: %s: %v", msg, err)
+		writeError(w, http.StatusInsufficientStorage, "Insufficient storage: the server cannot store more images right now")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, msg+": "+err.Error())
+}
+
+// storagePath returns the location on disk of a file stored under root, as
+// recorded in images.storage_root.
+func storagePath(root, diskFilename string) string {
+	return filepath.Join(storageRoot(root), filepath.FromSlash(diskFilename))
+}
+
+// storageRoot resolves a recorded storage root. Images stored before
+// multiple roots existed have none and live in uploadPath.
+func storageRoot(root string) string {
+	if root == "" {
+		return uploadPath
+	}
+	return root
+}
+
+// uploadRoots returns every directory holding stored files: the configured
+// UPLOAD_PATHS and uploadPath, which also keeps thumbnails and derived files.
+func uploadRoots() []string {
+	roots := slices.Clone(cfg.UploadPaths)
+	if !slices.Contains(roots, uploadPath) {
+		roots = append(roots, uploadPath)
+	}
+	return roots
+}
+
+// pickUploadRoot chooses the root for a new upload. A root whose free space
+// cannot be read is skipped by the most-free policy.
+func pickUploadRoot() string {
+	roots := cfg.UploadPaths
+	if len(roots) == 1 {
+		return roots[0]
+	}
+	if cfg.UploadPathPolicy == uploadPolicyRoundRobin {
+		return roots[(uploadRootCounter.Add(1)-1)%uint64(len(roots))]
+	}
+	best, bestFree := roots[0], uint64(0)
+	for _, root := range roots {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(root, &st); err != nil {
+			log.Printf("Warning: cannot read free space of %s: %v", root, err)
+			continue
+		}
+		if free := st.Bavail * uint64(st.Bsize); free > bestFree {
+			best, bestFree = root, free
+		}
+	}
+	return best
+}
+
+// validDiskFilename reports whether name is a well-formed disk filename that
+// stays inside its storage root.
+func validDiskFilename(name string) bool {
+	base := filepath.Base(name)
+	return diskFilenamePattern.MatchString(name) && base != "." && base != ".."
+}
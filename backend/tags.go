@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const maxTagLength = 64 // Matches the tags.name column
+
+// TagsRequest is the body of POST /api/images/{id}/tags.
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// normalizeTags lowercases, trims and deduplicates tags, preserving order.
+func normalizeTags(raw []string) ([]string, error) {
+	seen := make(map[string]bool, len(raw))
+	tags := make([]string, 0, len(raw))
+	for _, tag := range raw {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("tags must not be empty")
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q exceeds %d characters", tag, maxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func addImageTagsHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	var req TagsRequest
+	if !decodeJSONBody(w, r, &req, "tags") {
+		return
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid tags: "+err.Error())
+		return
+	}
+	if len(tags) == 0 {
+		writeError(w, http.StatusBadRequest, "At least one tag is required")
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	tx, err := dbBegin(ctx)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error starting transaction: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	var owner sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT owner FROM images WHERE id = $1", imageID).Scan(&owner); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	if !mayModifyImage(r, owner) {
+		writeError(w, http.StatusForbidden, "Only the uploader of this image or an admin may tag it")
+		return
+	}
+	if _, err := attachTags(ctx, tx, []int{imageID}, tags); err != nil {
+		writeError(w, dbErrorStatus(err), "Error saving tags: "+err.Error())
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error committing tags: "+err.Error())
+		return
+	}
+	imageCache.InvalidateID(imageID)
+
+	writeImageMetadata(w, r, imageID)
+}
+
+// attachTags creates any missing tags and links them to the given images,
+// returning the set of images that gained a tag.
+func attachTags(ctx context.Context, tx *sql.Tx, imageIDs []int, tags []string) (map[int]bool, error) {
+	if _, err := tx.ExecContext(ctx, "INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING", pq.Array(tags)); err != nil {
+		return nil, err
+	}
+	changed := make(map[int]bool)
+	err := collectImageIDs(ctx, tx, changed, `
+		INSERT INTO image_tags (image_id, tag_id)
+		SELECT i.id, t.id FROM unnest($1::int[]) AS i(id) CROSS JOIN tags t WHERE t.name = ANY($2)
+		ON CONFLICT DO NOTHING
+		RETURNING image_id`,
+		pq.Array(imageIDs), pq.Array(tags))
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// collectImageIDs runs query, which returns image IDs, adding each to ids.
+func collectImageIDs(ctx context.Context, tx *sql.Tx, ids map[int]bool, query string, args ...any) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids[id] = true
+	}
+	return rows.Err()
+}
+
+func removeImageTagHandler(w http.ResponseWriter, r *http.Request, imageID int, rawTag string) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Only DELETE method is allowed")
+		return
+	}
+
+	tags, err := normalizeTags([]string{rawTag})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid tag: "+err.Error())
+		return
+	}
+	tag := tags[0]
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	var owner sql.NullString
+	if err := dbQueryRow(ctx, "SELECT owner FROM images WHERE id = $1", imageID).Scan(&owner); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	if !mayModifyImage(r, owner) {
+		writeError(w, http.StatusForbidden, "Only the uploader of this image or an admin may untag it")
+		return
+	}
+
+	result, err := dbExec(ctx,
+		"DELETE FROM image_tags WHERE image_id = $1 AND tag_id = (SELECT id FROM tags WHERE name = $2)",
+		imageID, tag,
+	)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error removing tag: "+err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "Tag not found on image")
+		return
+	}
+	imageCache.InvalidateID(imageID)
+
+	writeImageMetadata(w, r, imageID)
+}
+
+const maxBulkTagImages = 1000
+
+// BulkTagRequest is the body of POST /api/images/bulk-tag.
+type BulkTagRequest struct {
+	IDs    []int    `json:"ids"`
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// BulkTagResponse reports how many images had their tags changed.
+type BulkTagResponse struct {
+	Affected int `json:"affected"`
+}
+
+// bulkTagHandler adds and removes tags across many images in one transaction.
+func bulkTagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	var req BulkTagRequest
+	if !decodeJSONBody(w, r, &req, "ids") {
+		return
+	}
+	imageIDs := uniqueIDs(req.IDs)
+	if len(imageIDs) == 0 || len(imageIDs) > maxBulkTagImages {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("ids must contain between 1 and %d images", maxBulkTagImages))
+		return
+	}
+	add, err := normalizeTags(req.Add)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid add tags: "+err.Error())
+		return
+	}
+	remove, err := normalizeTags(req.Remove)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid remove tags: "+err.Error())
+		return
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		writeError(w, http.StatusBadRequest, "At least one tag to add or remove is required")
+		return
+	}
+	for _, tag := range remove {
+		for _, a := range add {
+			if tag == a {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Tag %q cannot be both added and removed", tag))
+				return
+			}
+		}
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+
+	statuses, err := imageStatuses(ctx, r, imageIDs)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying images from database: "+err.Error())
+		return
+	}
+	missing, notOwned := unusableImages(statuses)
+	if len(missing) > 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Images not found: %v", missing))
+		return
+	}
+	if len(notOwned) > 0 {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("Not allowed to tag images: %v", notOwned))
+		return
+	}
+
+	tx, err := dbBegin(ctx)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error starting transaction: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	changed, err := bulkUpdateTags(ctx, tx, imageIDs, add, remove)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error updating tags: "+err.Error())
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error committing tags: "+err.Error())
+		return
+	}
+	for id := range changed {
+		imageCache.InvalidateID(id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BulkTagResponse{Affected: len(changed)})
+}
+
+// bulkUpdateTags links add and unlinks remove for every image in imageIDs,
+// returning the set of images whose tags actually changed.
+func bulkUpdateTags(ctx context.Context, tx *sql.Tx, imageIDs []int, add, remove []string) (map[int]bool, error) {
+	changed := make(map[int]bool)
+	if len(add) > 0 {
+		added, err := attachTags(ctx, tx, imageIDs, add)
+		if err != nil {
+			return nil, err
+		}
+		changed = added
+	}
+	if len(remove) > 0 {
+		err := collectImageIDs(ctx, tx, changed, `
+			DELETE FROM image_tags
+			WHERE image_id = ANY($1) AND tag_id IN (SELECT id FROM tags WHERE name = ANY($2))
+			RETURNING image_id`,
+			pq.Array(imageIDs), pq.Array(remove))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return changed, nil
+}
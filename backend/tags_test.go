@@ -0,0 +1,37 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     []string
+		want    []string
+		wantErr bool
+	}{
+		{"none", nil, []string{}, false},
+		{"lowercased and trimmed", []string{"  Chest ", "XRAY"}, []string{"chest", "xray"}, false},
+		{"duplicates dropped in order", []string{"b", "a", "B", " a"}, []string{"b", "a"}, false},
+		{"empty", []string{"ok", ""}, nil, true},
+		{"blank", []string{"   "}, nil, true},
+		{"at the length limit", []string{strings.Repeat("x", maxTagLength)}, []string{strings.Repeat("x", maxTagLength)}, false},
+		{"over the length limit", []string{strings.Repeat("x", maxTagLength+1)}, nil, true},
+		// The limit applies after trimming.
+		{"long only with spaces", []string{" " + strings.Repeat("x", maxTagLength) + " "}, []string{strings.Repeat("x", maxTagLength)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTags(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeTags(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("normalizeTags(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
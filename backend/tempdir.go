@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultTempDir is where multipart uploads spill to disk unless TEMP_DIR
+// says otherwise. Being under uploadPath keeps it on the uploads volume
+// rather than a container's possibly tiny /tmp.
+var defaultTempDir = filepath.Join(uploadPath, "tmp")
+
+// multipartSpillPrefix starts the names of the files mime/multipart creates
+// for form parts too large to hold in memory.
+const multipartSpillPrefix = "multipart-"
+
+// useTempDir creates dir and makes it the process's temporary directory.
+// mime/multipart has no option for where it spills parts, but it creates
+// them with os.CreateTemp(""), which follows TMPDIR.
+func useTempDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if err := os.Setenv("TMPDIR", dir); err != nil {
+		return err
+	}
+	if os.TempDir() != dir {
+		return fmt.Errorf("TMPDIR is not honoured on this platform")
+	}
+	return nil
+}
+
+// purgeMultipartSpills deletes spill files left in TEMP_DIR by uploads that
+// were interrupted before net/http could remove them, e.g. by a crash.
+func purgeMultipartSpills(ctx context.Context, now time.Time) (int, error) {
+	entries, err := os.ReadDir(cfg.TempDir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var firstErr error
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+		if !e.Type().IsRegular() || !strings.HasPrefix(e.Name(), multipartSpillPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || now.Sub(info.ModTime()) < staleTempAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cfg.TempDir, e.Name())); err != nil && !os.IsNotExist(err) {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		removed++
+	}
+	return removed, firstErr
+}
+
+// isTempDir reports whether a directory met while walking an upload root
+// is TEMP_DIR, whose files are not stored images.
+func isTempDir(d fs.DirEntry, path string) bool {
+	return d.IsDir() && path == cfg.TempDir
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+const thumbnailDir = "thumbnails" // Subdirectory of uploadPath holding generated thumbnails
+
+const thumbnailBatchSize = 100 // Images loaded per query when regenerating thumbnails
+
+// thumbnailPath returns the on-disk location of a thumbnail file.
+func thumbnailPath(thumbFilename string) string {
+	return filepath.Join(uploadPath, thumbnailDir, thumbFilename)
+}
+
+// generateThumbnail writes a JPEG thumbnail of the image stored as
+// diskFilename under root, scaled to fit within cfg.ThumbnailSize, and
+// returns its filename. Thumbnails of every root live under uploadPath.
+// A JPEG's EXIF orientation is applied to the thumbnail, whose own file has
+// no EXIF, so phone photos appear upright whether or not the original was
+// rewritten by STRIP_EXIF.
+func generateThumbnail(root, diskFilename string) (string, error) {
+	path := storagePath(root, diskFilename)
+	img, err := decodeImageFile(path)
+	if err != nil {
+		return "", fmt.Errorf("decoding image: %w", err)
+	}
+	// Scaling first keeps the per-pixel reorientation cheap.
+	scaled := orientImage(scaleToFit(img, cfg.ThumbnailSize), jpegFileOrientation(path))
+
+	// JPEG has no alpha channel, so flatten transparent areas onto white.
+	flat := image.NewRGBA(scaled.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), scaled, scaled.Bounds().Min, draw.Over)
+
+	thumbFilename := uuid.New().String() + ".jpg"
+	_, err = writeImageFile(thumbnailPath(thumbFilename), flat, func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: cfg.ThumbQuality})
+	})
+	if err != nil {
+		return "", fmt.Errorf("writing thumbnail: %w", err)
+	}
+	return thumbFilename, nil
+}
+
+// removeThumbnail deletes a thumbnail file, logging rather than failing.
+func removeThumbnail(thumbFilename sql.NullString) {
+	if !thumbFilename.Valid || thumbFilename.String == "" {
+		return
+	}
+	path := thumbnailPath(thumbFilename.String)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to delete thumbnail file %s: %v", path, err)
+	}
+}
+
+// thumbnailHandler serves GET /api/images/{id}/thumbnail.
+func thumbnailHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	var diskFilename string
+	var thumbFilename sql.NullString
+	err := dbQueryRow(ctx, "SELECT disk_filename, thumb_filename FROM images WHERE id = $1", imageID).Scan(&diskFilename, &thumbFilename)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	if !mayReadImageFile(w, r, diskFilename) {
+		return
+	}
+	if !thumbFilename.Valid {
+		writeError(w, http.StatusNotFound, "Image has no thumbnail")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, thumbnailPath(thumbFilename.String))
+}
+
+// regenerateThumbnailsHandler handles POST /api/admin/regenerate-thumbnails by
+// starting a background job that rebuilds every thumbnail from its original.
+func regenerateThumbnailsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+	job := backgroundJobs.Start("regenerate-thumbnails", regenerateAllThumbnails)
+	writeJobAccepted(w, r, job)
+}
+
+// regenerateAllThumbnails walks all images in ID order, in batches, replacing
+// each thumbnail. Images whose original cannot be decoded are logged and
+// counted as failed. It stops with ctx.Err() once ctx ends.
+func regenerateAllThumbnails(ctx context.Context, update func(func(*BackgroundJob))) error {
+	lastID := 0
+	for {
+		type pending struct {
+			id        int
+			root      string
+			diskName  string
+			thumbName sql.NullString
+		}
+		queryCtx, cancel := dbContext(ctx)
+		rows, err := dbQuery(queryCtx, "SELECT id, COALESCE(storage_root, ''), disk_filename, thumb_filename FROM images WHERE id > $1 ORDER BY id LIMIT $2", lastID, thumbnailBatchSize)
+		if err != nil {
+			cancel()
+			return err
+		}
+		var batch []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.root, &p.diskName, &p.thumbName); err != nil {
+				rows.Close()
+				cancel()
+				return err
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		cancel()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, p := range batch {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			lastID = p.id
+			// A superseded image was rotated, replaced or deleted meanwhile,
+			// which leaves nothing to regenerate.
+			err := replaceThumbnail(ctx, p.id, p.root, p.diskName, p.thumbName)
+			if err != nil && !errors.Is(err, errThumbnailSuperseded) {
+				log.Printf("Thumbnail regeneration failed for image %d (%s): %v", p.id, p.diskName, err)
+				update(func(j *BackgroundJob) { j.Failed++ })
+				continue
+			}
+			update(func(j *BackgroundJob) { j.Processed++ })
+		}
+	}
+}
+
+// errThumbnailSuperseded is returned by replaceThumbnail when the image's
+// file or thumbnail changed while the new thumbnail was being generated.
+var errThumbnailSuperseded = errors.New("image changed while its thumbnail was generated")
+
+// replaceThumbnail generates a fresh thumbnail for an image, records it and
+// removes the previous thumbnail file. Only the update is bounded by
+// DB_QUERY_TIMEOUT, not the image decoding before it, which waits for an
+// image processing worker unless parent ends first. The update only
+// applies while the row still has diskFilename and oldThumb, so a thumbnail
+// of a file that a rotation or replacement swapped out meanwhile is thrown
+// away instead of overwriting the newer one.
+func replaceThumbnail(parent context.Context, imageID int, root, diskFilename string, oldThumb sql.NullString) error {
+	var thumbFilename string
+	var genErr error
+	if err := imageProcessing.Run(parent, func() { thumbFilename, genErr = generateThumbnail(root, diskFilename) }); err != nil {
+		return err
+	}
+	if genErr != nil {
+		return genErr
+	}
+	ctx, cancel := dbContext(parent)
+	defer cancel()
+	result, err := dbExec(ctx,
+		"UPDATE images SET thumb_filename = $1 WHERE id = $2 AND disk_filename = $3 AND thumb_filename IS NOT DISTINCT FROM $4",
+		thumbFilename, imageID, diskFilename, oldThumb,
+	)
+	if err != nil {
+		os.Remove(thumbnailPath(thumbFilename))
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		os.Remove(thumbnailPath(thumbFilename))
+		return errThumbnailSuperseded
+	}
+	imageCache.InvalidateID(imageID)
+	removeThumbnail(oldThumb)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	httpAddr  = ":8080"
+	httpsAddr = ":8443" // Used instead of httpAddr when TLS is enabled
+)
+
+// tlsConfig restricts the server to TLS 1.2+ and, for TLS 1.2, to AEAD
+// cipher suites with forward secrecy. TLS 1.3 suites are not configurable.
+func tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// serve runs server over HTTPS when a certificate is configured and plain
+// HTTP otherwise. It only returns on failure.
+func serve(server *http.Server) error {
+	if !cfg.TLSEnabled() {
+		server.Addr = httpAddr
+		log.Printf("Starting Go backend server on %s (HTTP)...", httpAddr)
+		return server.ListenAndServe()
+	}
+
+	server.Addr = httpsAddr
+	server.TLSConfig = tlsConfig()
+	if cfg.TLSRedirect {
+		go serveHTTPSRedirect()
+	}
+	log.Printf("Starting Go backend server on %s (HTTPS)...", httpsAddr)
+	return server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+}
+
+// serveHTTPSRedirect answers plain HTTP requests with a permanent redirect to
+// the same URL on the HTTPS listener.
+func serveHTTPSRedirect() {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	redirect := &http.Server{
+		Addr:              httpAddr,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		WriteTimeout:      10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			target := "https://" + net.JoinHostPort(host, httpsPort) + r.URL.RequestURI()
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		}),
+	}
+	log.Printf("Redirecting HTTP on %s to HTTPS", httpAddr)
+	if err := redirect.ListenAndServe(); err != nil {
+		log.Fatalf("Could not start HTTP redirect listener: %v", err)
+	}
+}
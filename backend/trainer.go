@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Training job statuses.
+const (
+	trainingStatusPending   = "pending"   // Waiting for a trainer, or the simulator, to pick it up
+	trainingStatusSubmitted = "submitted" // Accepted by the trainer at TRAINER_URL
+	trainingStatusFailed    = "failed"    // The trainer could not be reached or refused the job
+	trainingStatusRunning   = "running"   // Being trained by the simulator; see progress
+	trainingStatusSucceeded = "succeeded" // Finished by the simulator
+	trainingStatusCancelled = "cancelled" // Cancelled with DELETE /api/ml/jobs/{id} while pending or running
+)
+
+// TrainerJob is the JSON body sent to the trainer for a new job.
+type TrainerJob struct {
+	JobID     int    `json:"job_id"`
+	ModelName string `json:"model_name"`
+	Epochs    int    `json:"epochs"`
+	ImageIDs  []int  `json:"image_ids"`
+}
+
+// Trainer starts training for a persisted job.
+type Trainer interface {
+	Start(ctx context.Context, job TrainerJob) error
+}
+
+var trainer Trainer // Set in main when TRAINER_URL is set; nil leaves jobs to runTrainingSimulator
+
+// trainerError is a failed call to the trainer. Temporary errors are worth
+// retrying; the others mean the trainer refused the job.
+type trainerError struct {
+	StatusCode int // HTTP status, 0 if no response was received
+	Temporary  bool
+	Err        error
+}
+
+func (e *trainerError) Error() string { return e.Err.Error() }
+func (e *trainerError) Unwrap() error { return e.Err }
+
+// httpTrainer POSTs each job to an external training service.
+type httpTrainer struct {
+	url    string
+	client *http.Client
+}
+
+func (t httpTrainer) Start(ctx context.Context, job TrainerJob) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return &trainerError{Temporary: true, Err: err}
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	return &trainerError{
+		StatusCode: resp.StatusCode,
+		Temporary:  resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout,
+		Err:        fmt.Errorf("trainer returned %s", resp.Status),
+	}
+}
+
+// submitTrainingJob hands a job to the trainer, retrying temporary failures
+// with jittered exponential backoff until TRAINER_RETRY_ATTEMPTS or
+// TRAINER_TOTAL_TIMEOUT runs out. Every attempt is recorded against the job,
+// and the job's status is set to submitted or failed. The returned error is
+// the last attempt's.
+func submitTrainingJob(parent context.Context, job TrainerJob) error {
+	ctx, cancel := context.WithTimeout(parent, cfg.TrainerTotalTimeout)
+	defer cancel()
+
+	delay := cfg.TrainerRetryBaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		err = trainer.Start(ctx, job)
+		recordTrainingAttempt(parent, job.JobID, attempt, started, err)
+		if err == nil {
+			setTrainingJobStatus(parent, job.JobID, trainingStatusSubmitted)
+			return nil
+		}
+
+		var terr *trainerError
+		if !errors.As(err, &terr) || !terr.Temporary || attempt >= cfg.TrainerRetryAttempts {
+			break
+		}
+		// Equal jitter: wait between half and all of the current delay.
+		wait := delay/2 + rand.N(delay/2+1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			break
+		}
+		log.Printf("Trainer call for job %d failed (attempt %d/%d), retrying in %v: %v", job.JobID, attempt, cfg.TrainerRetryAttempts, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		delay = min(delay*2, cfg.TrainerRetryMaxDelay)
+	}
+	setTrainingJobStatus(parent, job.JobID, trainingStatusFailed)
+	return err
+}
+
+// recordTrainingAttempt logs one trainer call in training_job_attempts. A
+// failure to record is logged but does not affect the submission.
+func recordTrainingAttempt(parent context.Context, jobID, attempt int, started time.Time, callErr error) {
+	var statusCode *int
+	var errMsg *string
+	if callErr != nil {
+		msg := callErr.Error()
+		errMsg = &msg
+		var terr *trainerError
+		if errors.As(callErr, &terr) && terr.StatusCode != 0 {
+			statusCode = &terr.StatusCode
+		}
+	}
+	ctx, cancel := dbContext(context.WithoutCancel(parent))
+	defer cancel()
+	_, err := dbExec(ctx,
+		"INSERT INTO training_job_attempts (job_id, attempt, started_at, duration_ms, status_code, error) VALUES ($1, $2, $3, $4, $5, $6)",
+		jobID, attempt, started.UTC(), time.Since(started).Milliseconds(), statusCode, errMsg,
+	)
+	if err != nil {
+		log.Printf("Warning: failed to record trainer attempt %d for job %d: %v", attempt, jobID, err)
+	}
+}
+
+// setTrainingJobStatus records the outcome of a submission. Only a pending
+// job is updated, so a job cancelled meanwhile stays cancelled.
+func setTrainingJobStatus(parent context.Context, jobID int, status string) {
+	ctx, cancel := dbContext(context.WithoutCancel(parent))
+	defer cancel()
+	if _, err := dbExec(ctx, "UPDATE training_jobs SET status = $1 WHERE id = $2 AND status = $3", status, jobID, trainingStatusPending); err != nil {
+		log.Printf("Warning: failed to set training job %d to %s: %v", jobID, status, err)
+		return
+	}
+	trainingJobChanges.Notify(jobID)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultTrainingEpochs = 10
+	maxTrainingEpochs     = 1000
+	maxModelNameLength    = 255 // Matches the training_jobs.model_name column
+)
+
+// TrainingRequest is the body of POST /api/ml/start-training.
+type TrainingRequest struct {
+	ImageIDs  []int  `json:"image_ids"`
+	ModelName string `json:"model_name"`
+	Epochs    int    `json:"epochs"`
+}
+
+// TrainingJob is a persisted training job specification.
+type TrainingJob struct {
+	ID        int       `json:"id"`
+	ModelName string    `json:"model_name"`
+	Epochs    int       `json:"epochs"`
+	Status    string    `json:"status"`
+	Progress  int       `json:"progress"` // Percentage, advanced by the training simulator
+	ImageIDs  []int64   `json:"image_ids"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Attempts []TrainingAttempt `json:"attempts"` // Calls made to the trainer, oldest first
+}
+
+// TrainingAttempt records one call to the trainer for a job.
+type TrainingAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	StatusCode *int      `json:"status_code,omitempty"` // HTTP status, absent if no response was received
+	Error      *string   `json:"error,omitempty"`       // Absent for the successful attempt
+}
+
+func startTrainingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	var req TrainingRequest
+	if !decodeJSONBody(w, r, &req, "image_ids", "model_name") {
+		return
+	}
+	req.ModelName = strings.TrimSpace(req.ModelName)
+	if req.ModelName == "" || len(req.ModelName) > maxModelNameLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("model_name is required and must be at most %d characters", maxModelNameLength))
+		return
+	}
+	if req.Epochs == 0 {
+		req.Epochs = defaultTrainingEpochs
+	}
+	if req.Epochs < 1 || req.Epochs > maxTrainingEpochs {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("epochs must be between 1 and %d", maxTrainingEpochs))
+		return
+	}
+	imageIDs := uniqueIDs(req.ImageIDs)
+	if len(imageIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "image_ids must list at least one image")
+		return
+	}
+
+	// Callers may only train on images they may modify; see mayModifyImage.
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	statuses, err := imageStatuses(ctx, r, imageIDs)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying images from database: "+err.Error())
+		return
+	}
+	missing, notOwned := unusableImages(statuses)
+	if len(missing) > 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Images not found: %v", missing))
+		return
+	}
+	if len(notOwned) > 0 {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("Not allowed to train on images: %v", notOwned))
+		return
+	}
+
+	var owner sql.NullString
+	if p := principalFromContext(r.Context()); p != nil {
+		owner = sql.NullString{String: p.ID, Valid: true}
+	}
+	jobID, err := createTrainingJob(ctx, req.ModelName, req.Epochs, imageIDs, owner)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error saving training job: "+err.Error())
+		return
+	}
+
+	log.Printf("Created training job %d for model %q with %d images.", jobID, req.ModelName, len(imageIDs))
+	location := externalURL(r, fmt.Sprintf("/api/ml/jobs/%d", jobID))
+
+	// Without TRAINER_URL training is still simulated: runTrainingSimulator
+	// picks the pending job up from the database.
+	message := "Solicitud de entrenamiento personalizado recibida. Proceso simulado iniciado."
+	if trainer != nil {
+		submitCtx, done := trainingSubmissions.Track(r.Context(), jobID)
+		err := submitTrainingJob(submitCtx, TrainerJob{JobID: jobID, ModelName: req.ModelName, Epochs: req.Epochs, ImageIDs: imageIDs})
+		cancelled := submitCtx.Err() != nil && r.Context().Err() == nil
+		done()
+		if err != nil {
+			log.Printf("Training job %d could not be submitted: %v", jobID, err)
+			w.Header().Set("Location", location)
+			var terr *trainerError
+			if cancelled {
+				writeError(w, http.StatusConflict, "Training job was cancelled before the trainer accepted it")
+			} else if errors.As(err, &terr) && !terr.Temporary {
+				writeError(w, http.StatusBadGateway, "Trainer rejected the job: "+err.Error())
+			} else {
+				writeError(w, http.StatusServiceUnavailable, "Trainer unavailable: "+err.Error())
+			}
+			return
+		}
+		message = "Training job submitted to the trainer."
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(SimpleResponse{Message: message, ID: jobID})
+}
+
+// uniqueIDs returns ids with duplicates removed, preserving order.
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// missingImageIDs returns the IDs in ids that have no images row.
+func missingImageIDs(ctx context.Context, ids []int) ([]int, error) {
+	rows, err := dbQuery(ctx, "SELECT id FROM images WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[int]bool, len(ids))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var missing []int
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// createTrainingJob persists a pending job together with its dataset.
+func createTrainingJob(ctx context.Context, modelName string, epochs int, imageIDs []int, owner sql.NullString) (int, error) {
+	tx, err := dbBegin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var jobID int
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO training_jobs (model_name, epochs, owner) VALUES ($1, $2, $3) RETURNING id",
+		modelName, epochs, owner,
+	).Scan(&jobID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO training_job_images (job_id, image_id) SELECT $1, unnest($2::int[])",
+		jobID, pq.Array(imageIDs),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return jobID, tx.Commit()
+}
+
+// trainingJobHandler routes GET and DELETE /api/ml/jobs/{id} and GET
+// /api/ml/jobs/{id}/wait and /api/ml/jobs/{id}/artifacts.
+func trainingJobHandler(w http.ResponseWriter, r *http.Request) {
+	idStr, subPath, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/ml/jobs/"), "/")
+	jobID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid job ID format")
+		return
+	}
+
+	switch {
+	case subPath == "" && r.Method == http.MethodDelete:
+		cancelTrainingJobHandler(w, r, jobID)
+	case r.Method != http.MethodGet:
+		writeError(w, http.StatusMethodNotAllowed, "Only GET and DELETE methods are allowed")
+	case subPath == "":
+		ctx, cancel := dbContext(r.Context())
+		defer cancel()
+		job, err := loadTrainingJob(ctx, jobID)
+		if err != nil {
+			writeTrainingJobError(w, err)
+			return
+		}
+		writeTrainingJob(w, job)
+	case subPath == "wait":
+		waitTrainingJobHandler(w, r, jobID)
+	case subPath == "artifacts":
+		jobArtifactsHandler(w, r, jobID)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// loadTrainingJob reads a job with its dataset and trainer attempts,
+// returning sql.ErrNoRows if it does not exist.
+func loadTrainingJob(ctx context.Context, jobID int) (TrainingJob, error) {
+	var job TrainingJob
+	err := dbQueryRow(ctx, `
+		SELECT id, model_name, epochs, status, progress, created_at,
+			COALESCE((SELECT array_agg(image_id ORDER BY image_id) FROM training_job_images WHERE job_id = training_jobs.id), '{}')
+		FROM training_jobs WHERE id = $1`, jobID,
+	).Scan(&job.ID, &job.ModelName, &job.Epochs, &job.Status, &job.Progress, &job.CreatedAt, pq.Array(&job.ImageIDs))
+	if err != nil {
+		return job, err
+	}
+
+	job.Attempts = []TrainingAttempt{}
+	rows, err := dbQuery(ctx, "SELECT attempt, started_at, duration_ms, status_code, error FROM training_job_attempts WHERE job_id = $1 ORDER BY attempt", jobID)
+	if err != nil {
+		return job, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a TrainingAttempt
+		if err := rows.Scan(&a.Attempt, &a.StartedAt, &a.DurationMS, &a.StatusCode, &a.Error); err != nil {
+			return job, err
+		}
+		job.Attempts = append(job.Attempts, a)
+	}
+	return job, rows.Err()
+}
+
+func writeTrainingJobError(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Training job not found")
+	} else {
+		writeError(w, dbErrorStatus(err), "Error querying training job from database: "+err.Error())
+	}
+}
+
+func writeTrainingJob(w http.ResponseWriter, job TrainingJob) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gen2brain/webp"
+)
+
+// RotateRequest is the body of POST /api/images/{id}/rotate. Degrees are
+// clockwise and must be 90, 180 or 270.
+type RotateRequest struct {
+	Degrees int `json:"degrees"`
+}
+
+// imageEncoders re-encode decoded images in their original format, with the
+// lossy ones at cfg.ConvertQuality.
+var imageEncoders = map[string]func(io.Writer, image.Image) error{
+	"image/jpeg": func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: cfg.ConvertQuality})
+	},
+	"image/png": png.Encode,
+	"image/gif": func(w io.Writer, img image.Image) error { return gif.Encode(w, img, nil) },
+	"image/webp": func(w io.Writer, img image.Image) error {
+		return webp.Encode(w, img, webp.Options{Quality: cfg.ConvertQuality})
+	},
+}
+
+func rotateImageHandler(w http.ResponseWriter, r *http.Request, imageID int) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	var req RotateRequest
+	if !decodeJSONBody(w, r, &req, "degrees") {
+		return
+	}
+	if req.Degrees != 90 && req.Degrees != 180 && req.Degrees != 270 {
+		writeError(w, http.StatusBadRequest, "degrees must be 90, 180 or 270")
+		return
+	}
+
+	var root, oldFilename, contentType string
+	var oldThumb, owner sql.NullString
+	lookupCtx, cancelLookup := dbContext(r.Context())
+	err := dbQueryRow(lookupCtx, "SELECT COALESCE(storage_root, ''), disk_filename, content_type, thumb_filename, owner FROM images WHERE id = $1", imageID).Scan(&root, &oldFilename, &contentType, &oldThumb, &owner)
+	cancelLookup()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Image not found")
+		} else {
+			writeError(w, dbErrorStatus(err), "Error querying image from database: "+err.Error())
+		}
+		return
+	}
+	if !mayModifyImage(r, owner) {
+		writeError(w, http.StatusForbidden, "Only the uploader of this image or an admin may rotate it")
+		return
+	}
+
+	encode, ok := imageEncoders[contentType]
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, "Rotation is not supported for content type "+contentType)
+		return
+	}
+
+	// The rotated file stays in the original's root.
+	newFilename, err := newDiskFilename(root, filepath.Ext(oldFilename))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Error creating the upload directory: "+err.Error())
+		return
+	}
+	newPath := storagePath(root, newFilename)
+
+	var bounds image.Rectangle
+	var size, phash int64
+	var contentHash string
+	var animated bool
+	var decodeErr, writeErr, hashErr error
+	err = imageProcessing.Run(r.Context(), func() {
+		// image/gif would decode and re-encode only the first frame.
+		if contentType == "image/gif" {
+			if animated, decodeErr = isAnimatedGIF(storagePath(root, oldFilename)); animated || decodeErr != nil {
+				return
+			}
+		}
+		img, err := decodeImageFile(storagePath(root, oldFilename))
+		if err != nil {
+			decodeErr = err
+			return
+		}
+		rotated := rotateImage(img, req.Degrees)
+		bounds, phash = rotated.Bounds(), int64(dHash(rotated))
+		if size, writeErr = writeImageFile(newPath, rotated, encode); writeErr != nil {
+			return
+		}
+		if contentHash, hashErr = fileSHA256(newPath); hashErr != nil {
+			os.Remove(newPath)
+		}
+	})
+	switch {
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, "Request ended while waiting for image processing: "+err.Error())
+		return
+	case decodeErr != nil:
+		writeError(w, http.StatusUnprocessableEntity, "Error decoding image: "+decodeErr.Error())
+		return
+	case animated:
+		writeError(w, http.StatusUnprocessableEntity, "Animated GIFs cannot be rotated without losing frames")
+		return
+	case writeErr != nil:
+		writeError(w, http.StatusInternalServerError, "Error writing rotated image: "+writeErr.Error())
+		return
+	case hashErr != nil:
+		writeError(w, http.StatusInternalServerError, "Error hashing rotated image: "+hashErr.Error())
+		return
+	}
+
+	// Matching the file read above makes a concurrent rotation or
+	// replacement, which would leave one of the two new files orphaned,
+	// fail here instead.
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	result, err := dbExec(ctx,
+		"UPDATE images SET disk_filename = $1, size = $2, width = $3, height = $4, phash = $5, content_sha256 = $6 WHERE id = $7 AND disk_filename = $8",
+		newFilename, size, bounds.Dx(), bounds.Dy(), phash, contentHash, imageID, oldFilename,
+	)
+	if err != nil {
+		os.Remove(newPath)
+		if isUniqueViolation(err, "images_owner_content_sha256_key") {
+			if existingID, lookupErr := findImageIDByHash(ctx, owner, contentHash); lookupErr == nil && existingID != 0 {
+				writeReplaceConflict(w, existingID)
+				return
+			}
+		}
+		writeError(w, dbErrorStatus(err), "Error updating image metadata: "+err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		os.Remove(newPath)
+		writeError(w, http.StatusConflict, "The image was changed or deleted while it was being rotated; fetch its current state before rotating it again")
+		return
+	}
+	imageCache.InvalidateID(imageID)
+
+	oldPath := storagePath(root, oldFilename)
+	if err := os.Remove(oldPath); err != nil {
+		log.Printf("Warning: failed to delete replaced image file %s: %v", oldPath, err)
+	}
+	removeDerivedImages(oldFilename)
+
+	// The file is already rotated, so its thumbnail is made even if the
+	// client goes away while it waits for a worker.
+	if err := replaceThumbnail(context.WithoutCancel(r.Context()), imageID, root, newFilename, oldThumb); err != nil {
+		log.Printf("Warning: failed to regenerate thumbnail for rotated image %d: %v", imageID, err)
+	}
+
+	writeImageMetadata(w, r, imageID)
+}
+
+// decodeImageFile decodes the image stored at path, including HEIF files
+// when HEIC_CONVERTER is configured.
+func decodeImageFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err == image.ErrFormat && isHEIFFile(path) {
+		return decodeHEIFFile(path)
+	}
+	return img, err
+}
+
+// isAnimatedGIF reports whether the GIF at path has more than one frame.
+func isAnimatedGIF(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		return false, err
+	}
+	return len(g.Image) > 1, nil
+}
+
+// writeImageFile encodes img to a new file at path and returns its size. The
+// file is removed again if encoding fails.
+func writeImageFile(path string, img image.Image, encode func(io.Writer, image.Image) error) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	err = encode(f, img)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// imageDimensions reads the pixel size of the image at path from its header.
+// It returns nil values for files that are neither decodable images nor HEIF.
+func imageDimensions(path string) (width, height *int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return heifDimensions(path)
+	}
+	return &config.Width, &config.Height
+}
+
+// resolutionError returns why the image at path is over MAX_MEGAPIXELS, or ""
+// if it is within the limit or its size cannot be read from its header.
+// Only the header is read, so images too large to decode safely are caught
+// before anything decodes them.
+func resolutionError(path string) string {
+	if cfg.MaxMegapixels == 0 {
+		return ""
+	}
+	width, height := imageDimensions(path)
+	if width == nil {
+		return ""
+	}
+	if pixels := int64(*width) * int64(*height); pixels > int64(cfg.MaxMegapixels)*1_000_000 {
+		return fmt.Sprintf("image is %d×%d (%.1f megapixels), over the limit of %d megapixels", *width, *height, float64(pixels)/1e6, cfg.MaxMegapixels)
+	}
+	return ""
+}
+
+// rotateImage rotates img clockwise by 90, 180 or 270 degrees.
+func rotateImage(img image.Image, degrees int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dst *image.NRGBA
+	if degrees == 180 {
+		dst = image.NewNRGBA(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			switch degrees {
+			case 90:
+				dst.Set(h-1-y, x, c)
+			case 180:
+				dst.Set(w-1-x, h-1-y, c)
+			case 270:
+				dst.Set(y, w-1-x, c)
+			}
+		}
+	}
+	return dst
+}
+
+// scaleToFit downscales img so neither side exceeds maxSide, preserving the
+// aspect ratio. Images already small enough are returned unchanged.
+func scaleToFit(img image.Image, maxSide int) image.Image {
+	return scaleToBox(img, maxSide, maxSide)
+}
+
+// fitDimensions returns the largest size with the aspect ratio of w×h that
+// fits within maxW×maxH, never exceeding the original size.
+func fitDimensions(w, h, maxW, maxH int) (int, int) {
+	if w <= maxW && h <= maxH {
+		return w, h
+	}
+	// Compare w/maxW with h/maxH to find the constraining side.
+	if w*maxH >= h*maxW {
+		return maxW, max(1, h*maxW/w)
+	}
+	return max(1, w*maxH/h), maxH
+}
+
+// scaleToBox downscales img to fit within maxW×maxH, preserving the aspect
+// ratio. Each output pixel averages the source pixels it covers. Images
+// already small enough are returned unchanged.
+func scaleToBox(img image.Image, maxW, maxH int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := fitDimensions(w, h, maxW, maxH)
+	if dw == w && dh == h {
+		return img
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		sy0, sy1 := b.Min.Y+dy*h/dh, b.Min.Y+(dy+1)*h/dh
+		for dx := 0; dx < dw; dx++ {
+			sx0, sx1 := b.Min.X+dx*w/dw, b.Min.X+(dx+1)*w/dw
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(dx, dy, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
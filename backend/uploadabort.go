@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// statusClientClosedRequest is recorded, for the access log only, when the
+// client goes away mid-upload; there is nobody left to read a response. The
+// code is nginx's.
+const statusClientClosedRequest = 499
+
+// contextReader fails reads once ctx is done, so a copy stops promptly when
+// the request is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// clientGone reports whether err, from reading the request body or from work
+// bound to the request context, means the client has disconnected. While the
+// body is being read a dropped connection shows up as a truncated read; after
+// that, as a cancelled context.
+func clientGone(r *http.Request, err error) bool {
+	return r.Context().Err() != nil ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// abortUpload records an upload abandoned by its client. The caller has
+// already removed anything it stored. Disconnects are routine on mobile
+// networks, so they are only logged with DEBUG_LOGGING.
+func abortUpload(w http.ResponseWriter, stage string, err error) {
+	uploadsAborted.Add(1)
+	debugf("Upload aborted by client %s (request_id=%s): %v", stage, w.Header().Get("X-Request-ID"), err)
+	w.WriteHeader(statusClientClosedRequest)
+}
+
+// debugf logs only when DEBUG_LOGGING is on.
+func debugf(format string, args ...any) {
+	if cfg.DebugLogging {
+		log.Printf("DEBUG "+format, args...)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// What happens to an upload that arrives while MAX_CONCURRENT_UPLOADS are in
+// progress, chosen with UPLOAD_LIMIT_MODE.
+const (
+	uploadLimitWait   = "wait"   // Queue for up to UPLOAD_QUEUE_TIMEOUT, then 503
+	uploadLimitReject = "reject" // 503 straight away
+)
+
+// uploadBusyRetryAfter is the Retry-After hint, in seconds, sent when an
+// upload is refused for lack of a slot.
+const uploadBusyRetryAfter = "5"
+
+var uploadSlots *semaphore.Weighted // Sized from MAX_CONCURRENT_UPLOADS in main
+
+// acquireUploadSlot reserves one of the MAX_CONCURRENT_UPLOADS slots,
+// waiting or not according to UPLOAD_LIMIT_MODE. When it returns false it
+// has already written a 503 and the caller must stop; otherwise the caller
+// must call releaseUploadSlot once the upload is finished.
+func acquireUploadSlot(w http.ResponseWriter, r *http.Request) bool {
+	acquired := uploadSlots.TryAcquire(1)
+	if !acquired && cfg.UploadLimitMode == uploadLimitWait {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.UploadQueueTimeout)
+		acquired = uploadSlots.Acquire(ctx, 1) == nil
+		cancel()
+	}
+	if !acquired {
+		uploadsRejectedBusy.Add(1)
+		w.Header().Set("Retry-After", uploadBusyRetryAfter)
+		writeError(w, http.StatusServiceUnavailable, "Too many uploads in progress, please retry shortly")
+		return false
+	}
+	uploadsInFlight.Add(1)
+	return true
+}
+
+func releaseUploadSlot() {
+	uploadsInFlight.Add(-1)
+	uploadSlots.Release(1)
+}
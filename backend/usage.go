@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Bounds of ?days= on GET /api/admin/usage-history.
+const (
+	defaultUsageHistoryDays = 30
+	maxUsageHistoryDays     = 366
+)
+
+// DailyUsage is one day of GET /api/admin/usage-history. Uploads count every
+// image uploaded that day, including ones deleted since; deletions count the
+// images deleted that day. Deletions recorded before sizes were tracked add
+// to DeletedCount but not DeletedBytes.
+type DailyUsage struct {
+	Date          string `json:"date"` // YYYY-MM-DD in the database server's time zone
+	UploadedCount int    `json:"uploaded_count"`
+	UploadedBytes int64  `json:"uploaded_bytes"`
+	DeletedCount  int    `json:"deleted_count"`
+	DeletedBytes  int64  `json:"deleted_bytes"`
+	NetCount      int    `json:"net_count"`
+	NetBytes      int64  `json:"net_bytes"`
+}
+
+// usageHistoryHandler serves GET /api/admin/usage-history?days=N: daily
+// upload and deletion totals for the last N days, today included, oldest
+// first. Days without activity are listed with zeros so the series can be
+// charted as is. It is wrapped in requireAdmin.
+func usageHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+	days := defaultUsageHistoryDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxUsageHistoryDays {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("days must be an integer between 1 and %d", maxUsageHistoryDays))
+			return
+		}
+		days = n
+	}
+
+	// Images deleted since their upload are no longer in images, so their
+	// upload is counted from image_deletions instead. The columns are
+	// TIMESTAMP, hence LOCALTIMESTAMP rather than now().
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	rows, err := dbQuery(ctx, `
+		WITH days AS (
+			SELECT generate_series(date_trunc('day', LOCALTIMESTAMP) - ($1 - 1) * INTERVAL '1 day', date_trunc('day', LOCALTIMESTAMP), INTERVAL '1 day') AS day
+		), uploads AS (
+			SELECT date_trunc('day', uploaded_at) AS day, COUNT(*) AS n, SUM(size) AS bytes
+			FROM (
+				SELECT uploaded_at, size FROM images
+				UNION ALL
+				SELECT uploaded_at, size FROM image_deletions WHERE uploaded_at IS NOT NULL
+			) u
+			WHERE uploaded_at >= (SELECT MIN(day) FROM days)
+			GROUP BY 1
+		), deletions AS (
+			SELECT date_trunc('day', deleted_at) AS day, COUNT(*) AS n, COALESCE(SUM(size), 0) AS bytes
+			FROM image_deletions
+			WHERE deleted_at >= (SELECT MIN(day) FROM days)
+			GROUP BY 1
+		)
+		SELECT d.day, COALESCE(u.n, 0), COALESCE(u.bytes, 0), COALESCE(x.n, 0), COALESCE(x.bytes, 0)
+		FROM days d
+		LEFT JOIN uploads u ON u.day = d.day
+		LEFT JOIN deletions x ON x.day = d.day
+		ORDER BY d.day`, days)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying database: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	history := make([]DailyUsage, 0, days)
+	for rows.Next() {
+		var day time.Time
+		var u DailyUsage
+		if err := rows.Scan(&day, &u.UploadedCount, &u.UploadedBytes, &u.DeletedCount, &u.DeletedBytes); err != nil {
+			writeError(w, dbErrorStatus(err), "Error scanning database results: "+err.Error())
+			return
+		}
+		u.Date = day.Format(time.DateOnly)
+		u.NetCount = u.UploadedCount - u.DeletedCount
+		u.NetBytes = u.UploadedBytes - u.DeletedBytes
+		history = append(history, u)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, dbErrorStatus(err), "Error reading database results: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
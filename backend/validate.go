@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+const maxValidateImages = 1000
+
+// ValidateImagesRequest is the body of POST /api/images/validate.
+type ValidateImagesRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// ImageStatus reports what the caller can do with one image.
+type ImageStatus struct {
+	ID      int  `json:"id"`
+	Exists  bool `json:"exists"`
+	Owned   bool `json:"owned"`   // The caller may modify it; see mayModifyImage
+	Deleted bool `json:"deleted"` // It existed once and has been deleted
+}
+
+// validateImagesHandler serves POST /api/images/validate, reporting for each
+// requested ID, in request order, whether it exists and may be modified by
+// the caller. Nothing is changed.
+func validateImagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	var req ValidateImagesRequest
+	if !decodeJSONBody(w, r, &req, "ids") {
+		return
+	}
+	imageIDs := uniqueIDs(req.IDs)
+	if len(imageIDs) == 0 || len(imageIDs) > maxValidateImages {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("ids must contain between 1 and %d images", maxValidateImages))
+		return
+	}
+
+	ctx, cancel := dbContext(r.Context())
+	defer cancel()
+	statuses, err := imageStatuses(ctx, r, imageIDs)
+	if err != nil {
+		writeError(w, dbErrorStatus(err), "Error querying images from database: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// imageStatuses looks up imageIDs for the caller of r, returning one status
+// per ID in the same order.
+func imageStatuses(ctx context.Context, r *http.Request, imageIDs []int) ([]ImageStatus, error) {
+	rows, err := dbQuery(ctx, `
+		SELECT q.id, i.id IS NOT NULL, i.owner,
+			i.id IS NULL AND EXISTS (SELECT 1 FROM image_deletions d WHERE d.image_id = q.id)
+		FROM unnest($1::int[]) WITH ORDINALITY AS q(id, n)
+		LEFT JOIN images i ON i.id = q.id
+		ORDER BY q.n`, pq.Array(imageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	statuses := make([]ImageStatus, 0, len(imageIDs))
+	for rows.Next() {
+		var s ImageStatus
+		var owner sql.NullString
+		if err := rows.Scan(&s.ID, &s.Exists, &owner, &s.Deleted); err != nil {
+			return nil, err
+		}
+		s.Owned = s.Exists && mayModifyImage(r, owner)
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
+
+// unusableImages returns the IDs in statuses that do not exist and those the
+// caller may not modify.
+func unusableImages(statuses []ImageStatus) (missing, notOwned []int) {
+	for _, s := range statuses {
+		if !s.Exists {
+			missing = append(missing, s.ID)
+		} else if !s.Owned {
+			notOwned = append(notOwned, s.ID)
+		}
+	}
+	return missing, notOwned
+}
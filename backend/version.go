@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.gitCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+var (
+	gitCommit string
+	buildTime string
+)
+
+// VersionResponse is returned by GET /version.
+type VersionResponse struct {
+	GitCommit string        `json:"git_commit"`
+	BuildTime string        `json:"build_time"`
+	GoVersion string        `json:"go_version"`
+	Config    VersionConfig `json:"config"`
+}
+
+// VersionConfig lists running settings useful for support. Nothing secret
+// (credentials, signing keys, service URLs) belongs here.
+type VersionConfig struct {
+	StorageBackend      string   `json:"storage_backend"`
+	MaxUploadSize       int64    `json:"max_upload_size"`
+	MaxMegapixels       int      `json:"max_megapixels"` // 0 when unlimited
+	AllowedContentTypes []string `json:"allowed_content_types"`
+	DedupMode           string   `json:"dedup_mode"`
+	ConvertToWebP       bool     `json:"convert_to_webp"`
+	TLS                 bool     `json:"tls"`
+	URLSigning          bool     `json:"url_signing"`
+	Moderation          bool     `json:"moderation"`
+	EventWebhook        bool     `json:"event_webhook"`
+	MaintenanceMode     bool     `json:"maintenance_mode"`
+	DefaultPageSize     int      `json:"default_page_size"`
+	MaxPageSize         int      `json:"max_page_size"`
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	resp := VersionResponse{
+		GitCommit: gitCommit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+		Config: VersionConfig{
+			StorageBackend:      "filesystem",
+			MaxUploadSize:       maxUploadSize,
+			MaxMegapixels:       cfg.MaxMegapixels,
+			AllowedContentTypes: cfg.AllowedContentTypes,
+			DedupMode:           cfg.DedupMode,
+			ConvertToWebP:       cfg.ConvertToWebP,
+			TLS:                 cfg.TLSEnabled(),
+			URLSigning:          urlSigningEnabled(),
+			Moderation:          cfg.ModerationURL != "",
+			EventWebhook:        cfg.EventWebhookURL != "",
+			MaintenanceMode:     maintenanceMode.Load(),
+			DefaultPageSize:     cfg.DefaultPageSize,
+			MaxPageSize:         cfg.MaxPageSize,
+		},
+	}
+	// Builds from a git checkout without -ldflags still embed the revision.
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && resp.GitCommit == "":
+				resp.GitCommit = s.Value
+			case s.Key == "vcs.time" && resp.BuildTime == "":
+				resp.BuildTime = s.Value
+			}
+		}
+	}
+	if resp.GitCommit == "" {
+		resp.GitCommit = "unknown"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
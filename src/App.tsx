@@ -169,7 +169,41 @@ const App: React.FC = () => {
       // or will be relative if frontend is served by the same domain as backend in production.
       // For Docker setup, frontend is on 5173, backend on 8080.
       // We'll assume a direct call for now, or that a proxy is set up in vite.config.ts if needed.
-      const response = await fetch('/api/ml/start-training', { method: 'POST' });
+
+      // Training runs on the images stored on the server, not the ones kept in IndexedDB.
+      const listResponse = await fetch('/api/images?limit=1000');
+      const list = await listResponse.json();
+      if (!listResponse.ok) {
+        setTrainingStatus(`Error del backend: ${list.error || 'Error desconocido'}`);
+        return;
+      }
+      const listedIds: number[] = list.images.map((img: { id: number }) => img.id);
+      let imageIds: number[] = [];
+      if (listedIds.length > 0) {
+        // Only images this client may use are accepted for training.
+        const validateResponse = await fetch('/api/images/validate', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ ids: listedIds }),
+        });
+        const statuses = await validateResponse.json();
+        if (!validateResponse.ok) {
+          setTrainingStatus(`Error del backend: ${statuses.error || 'Error desconocido'}`);
+          return;
+        }
+        imageIds = statuses
+          .filter((s: { exists: boolean; owned: boolean }) => s.exists && s.owned)
+          .map((s: { id: number }) => s.id);
+      }
+      if (imageIds.length === 0) {
+        setTrainingStatus("No hay imágenes en el servidor para entrenar el modelo.");
+        return;
+      }
+      const response = await fetch('/api/ml/start-training', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ image_ids: imageIds, model_name: 'modelo-personalizado' }),
+      });
       const data = await response.json();
       if (response.ok) {
         setTrainingStatus(`Respuesta del backend: ${data.message}`);